@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+type AnalyseOpts struct {
+	format      string
+	thresholds  cfspeed.Thresholds
+	scoreBounds map[string]string
+}
+
+// analyse derives results from a trace afresh, assessing them the same way as live runs
+func analyse(tracePath string, analyseOpts *AnalyseOpts) error {
+	if err := analyseOpts.thresholds.Validate(); err != nil {
+		return err
+	}
+	scoreThresholds, err := getScoreThresholds(analyseOpts.scoreBounds)
+	if err != nil {
+		return err
+	}
+
+	resultWriter, err := cfspeed.NewResultWriter(analyseOpts.format, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	traceFile, err := os.Open(tracePath)
+	if err != nil {
+		return err
+	}
+	defer traceFile.Close()
+
+	trace, err := cfspeed.LoadTrace(traceFile)
+	if err != nil {
+		return err
+	}
+
+	runOpts := &cfspeed.RunOptions{
+		Thresholds:      &analyseOpts.thresholds,
+		ScoreThresholds: scoreThresholds,
+	}
+
+	thresholdsMet := true
+	for _, runTrace := range trace.Runs {
+		result, err := runTrace.Analyse(runOpts)
+		if err != nil {
+			return err
+		}
+
+		if err := resultWriter.WriteRun(result); err != nil {
+			return err
+		}
+		thresholdsMet = thresholdsMet && result.ChecksPassed()
+	}
+
+	if err := resultWriter.Close(); err != nil {
+		return err
+	}
+	if !thresholdsMet {
+		return errThresholdsNotMet
+	}
+
+	return nil
+}
+
+func newAnalyseCommand() *cobra.Command {
+	analyseOpts := &AnalyseOpts{}
+
+	cmd := &cobra.Command{
+		Use:          "analyse TRACE",
+		Aliases:      []string{"analyze"},
+		Short:        "Analyse raw measurements recorded with --record",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			return analyse(args[0], analyseOpts)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	addThresholdFlags(flags, &analyseOpts.thresholds, &analyseOpts.scoreBounds)
+	flags.StringVarP(&analyseOpts.format, "format", "f", cfspeed.FormatText, "output format (text, json, ndjson, csv, prometheus)")
+
+	return cmd
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1024,
+	"mib": 1024 * 1024,
+	"gib": 1024 * 1024 * 1024,
+	"tib": 1024 * 1024 * 1024 * 1024,
+}
+
+var byteSizePattern = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*([A-Za-z]*)\s*$`)
+
+// parseByteSize parses sizes such as "200MB" (decimal) and "512MiB" (binary) into bytes
+func parseByteSize(value string) (int64, error) {
+	match := byteSizePattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf(`invalid size "%s"; it needs to be a number followed by an optional unit such as MB or MiB`, value)
+	}
+
+	unit, ok := byteSizeUnits[strings.ToLower(match[2])]
+	if !ok {
+		return 0, fmt.Errorf(`invalid size "%s"; unknown unit "%s"`, value, match[2])
+	}
+
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid size "%s": %w`, value, err)
+	}
+
+	return int64(number * float64(unit)), nil
+}
+
+func formatByteSize(size int64) string {
+	for _, unit := range []string{"TiB", "TB", "GiB", "GB", "MiB", "MB", "KiB", "kB"} {
+		unitSize := byteSizeUnits[strings.ToLower(unit)]
+		if size >= unitSize && size%unitSize == 0 {
+			return fmt.Sprintf("%d%s", size/unitSize, unit)
+		}
+	}
+
+	return fmt.Sprintf("%dB", size)
+}
+
+// byteSizeValue is a pflag.Value of a size in bytes, which accepts units
+type byteSizeValue struct {
+	size *int64
+}
+
+func newByteSizeValue(size *int64) *byteSizeValue {
+	return &byteSizeValue{size: size}
+}
+
+func (v *byteSizeValue) String() string {
+	if v.size == nil {
+		return ""
+	}
+
+	return formatByteSize(*v.size)
+}
+
+func (v *byteSizeValue) Set(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+
+	*v.size = size
+
+	return nil
+}
+
+func (v *byteSizeValue) Type() string {
+	return "size"
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"1024":    1024,
+		"200MB":   200 * 1000 * 1000,
+		"200 mb":  200 * 1000 * 1000,
+		"512MiB":  512 * 1024 * 1024,
+		"1.5GiB":  1536 * 1024 * 1024,
+		"10kB":    10 * 1000,
+		"3b":      3,
+		"0.5 KiB": 512,
+	} {
+		size, err := parseByteSize(value)
+		assert.NilError(t, err, value)
+		assert.Equal(t, size, expected, value)
+	}
+
+	for _, value := range []string{"", "MB", "-1MB", "10 PB", "1e3"} {
+		_, err := parseByteSize(value)
+		assert.ErrorContains(t, err, "invalid size", value)
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	assert.Equal(t, formatByteSize(512*1024*1024), "512MiB")
+	assert.Equal(t, formatByteSize(200*1000*1000), "200MB")
+	assert.Equal(t, formatByteSize(1000*1024), "1000KiB")
+	assert.Equal(t, formatByteSize(1234567), "1234567B")
+	assert.Equal(t, formatByteSize(0), "0B")
+}
@@ -0,0 +1,102 @@
+package cfspeed
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+const (
+	budgetChunksPerConnection = 4               // Downloads per connection the budget of a phase is split into at least
+	budgetChunkMin            = 1 * 1024 * 1024 // Minimum size of downloads under a budget
+)
+
+var errByteBudgetExhausted = errors.New("data budget exhausted")
+
+// ByteBudget caps bytes transferred by speed measurements made with a context carrying it.
+// It is safe for concurrent use as multiplexed measurements share a budget.
+type ByteBudget struct {
+	remaining atomic.Int64
+	used      atomic.Int64
+	truncated atomic.Bool
+	chunkMax  int64
+}
+
+// NewByteBudget makes a budget of size bytes, handing out downloads of up to chunkMax bytes each
+func NewByteBudget(size int64, chunkMax int64) *ByteBudget {
+	b := &ByteBudget{
+		chunkMax: chunkMax,
+	}
+	b.remaining.Store(size)
+
+	return b
+}
+
+// take grants up to size bytes, marking the budget truncated if fewer are left
+func (b *ByteBudget) take(size int64) int64 {
+	if b == nil {
+		return size
+	}
+
+	for {
+		remaining := b.remaining.Load()
+
+		granted := min(size, remaining)
+		if b.remaining.CompareAndSwap(remaining, remaining-granted) {
+			if granted < size {
+				b.truncated.Store(true)
+			}
+			b.used.Add(granted)
+
+			return granted
+		}
+	}
+}
+
+// refund returns bytes granted but not transferred
+func (b *ByteBudget) refund(size int64) {
+	if b != nil && size > 0 {
+		b.remaining.Add(size)
+		b.used.Add(-size)
+	}
+}
+
+// Used tells bytes transferred within the budget
+func (b *ByteBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+
+	return b.used.Load()
+}
+
+// Truncated tells whether measurements have been cut short by the budget
+func (b *ByteBudget) Truncated() bool {
+	return b != nil && b.truncated.Load()
+}
+
+// getDownloadSize determines the size of a download of up to maxSize bytes under the budget
+func (b *ByteBudget) getDownloadSize(maxSize int64) int64 {
+	if b == nil {
+		return maxSize
+	}
+
+	return b.take(min(maxSize, b.chunkMax))
+}
+
+type byteBudgetKey struct{}
+
+// WithByteBudget returns a context making measurements stay within the budget given
+func WithByteBudget(ctx context.Context, budget *ByteBudget) context.Context {
+	return context.WithValue(ctx, byteBudgetKey{}, budget)
+}
+
+func getByteBudget(ctx context.Context) *ByteBudget {
+	budget, _ := ctx.Value(byteBudgetKey{}).(*ByteBudget)
+	return budget
+}
+
+// newPhaseByteBudget makes a budget for a speed measurement phase, split into downloads so that every connection gets a share
+func newPhaseByteBudget(size int64, multiplicity int) *ByteBudget {
+	return NewByteBudget(size, max(size/int64(budgetChunksPerConnection*max(multiplicity, 1)), budgetChunkMin))
+}
@@ -0,0 +1,92 @@
+package cfspeed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestByteBudget_Concurrent(t *testing.T) {
+	budget := NewByteBudget(1000, 100)
+	waitGroup := &sync.WaitGroup{}
+
+	for iter := 0; iter < 20; iter += 1 {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for budget.take(7) > 0 {
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	assert.Equal(t, budget.Used(), int64(1000))
+	assert.Assert(t, budget.Truncated())
+
+	budget.refund(100)
+	assert.Equal(t, budget.Used(), int64(900))
+	assert.Equal(t, budget.getDownloadSize(512), int64(100))
+}
+
+func TestByteBudget_Nil(t *testing.T) {
+	var budget *ByteBudget = nil
+
+	assert.Equal(t, budget.take(100), int64(100))
+	assert.Equal(t, budget.getDownloadSize(100), int64(100))
+	assert.Assert(t, !budget.Truncated())
+}
+
+func TestNewPhaseByteBudget(t *testing.T) {
+	assert.Equal(t, newPhaseByteBudget(400*1024*1024, 4).chunkMax, int64(25*1024*1024))
+	assert.Equal(t, newPhaseByteBudget(2*1024*1024, 4).chunkMax, int64(budgetChunkMin))
+	assert.Equal(t, newPhaseByteBudget(40*1024*1024, 0).chunkMax, int64(10*1024*1024))
+}
+
+func TestMeasureSpeed_ByteBudget(t *testing.T) {
+	client := startDummyServer(t)
+	client.Measurement.SpeedDuration = 3 * time.Second
+
+	for _, measureFunc := range []func(context.Context, int) (*SpeedMeasurementStats, error){client.MeasureDownlink, client.MeasureUplink} {
+		budget := newPhaseByteBudget(20*1024*1024, 4)
+
+		start := time.Now()
+		stats, err := measureFunc(WithByteBudget(context.Background(), budget), 4)
+		assert.NilError(t, err)
+
+		// the dummy server is fast enough to exhaust the budget well before the duration elapses
+		assert.Assert(t, time.Since(start) < client.Measurement.SpeedDuration)
+		assert.Assert(t, budget.Truncated())
+		assert.Assert(t, stats.TXSize <= 20*1024*1024)
+		assert.Equal(t, stats.TXSize, budget.Used())
+	}
+}
+
+func TestDownlinkMeasurement_FailureRefundsBudget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", r.URL.Query().Get("bytes"))
+		w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	t.Cleanup(server.Close)
+
+	config := NewConfig()
+	config.BaseURL = server.URL
+	client, err := NewClient(config)
+	assert.NilError(t, err)
+	t.Cleanup(client.CloseIdleConnections)
+
+	budget := NewByteBudget(1024*1024, 1024*1024)
+	_, err = client.doDownlinkMeasurement(WithByteBudget(context.Background(), budget), 1024*1024, time.Now().Add(time.Second))
+	assert.Assert(t, err != nil)
+
+	// only the bytes received before the failure are used up
+	assert.Assert(t, budget.Used() <= 1024)
+	assert.Equal(t, budget.remaining.Load(), 1024*1024-budget.Used())
+	assert.Assert(t, !budget.Truncated())
+}
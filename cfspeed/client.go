@@ -0,0 +1,159 @@
+package cfspeed
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Client makes measurements against a speed test server.
+// Every Client owns its HTTP client and transport, so clients over different networks may run concurrently.
+type Client struct {
+	BaseURL     string        // Base URL of the speed test server
+	Network     string        // Network to dial, i.e. "tcp", "tcp4" or "tcp6", or "udp", "udp4" or "udp6" for HTTP/3; consulted on every dial
+	DialTimeout time.Duration // Timeout of establishing a connection; consulted on every dial
+	TLSConfig   *tls.Config   // TLS settings shared with the transport of HTTPClient
+	HTTPClient  *http.Client
+	Measurement MeasurementOptions // Bounds of measurements
+}
+
+func NewClient(config *Config) (*Client, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		BaseURL:     config.BaseURL,
+		Network:     config.Network,
+		DialTimeout: config.DialTimeout,
+		TLSConfig:   tlsConfig,
+		Measurement: config.Measurement,
+	}
+
+	if isQUICNetwork(c.Network) {
+		c.HTTPClient = &http.Client{
+			Transport: &http3.Transport{
+				TLSClientConfig: c.TLSConfig,
+				Dial:            c.dialQUIC,
+			},
+		}
+
+		return c, nil
+	}
+
+	// cf. https://go.googlesource.com/go/+/refs/tags/go1.22.1/src/net/http/transport.go#43
+	// cf. https://go.googlesource.com/go/+/refs/tags/go1.22.1/src/net/http/transport.go#140
+	c.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           c.dialContext,
+			TLSClientConfig:       c.TLSConfig,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+
+	return c, nil
+}
+
+func (c *Client) dialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	return (&net.Dialer{
+		Timeout:   c.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext(ctx, c.Network, addr)
+}
+
+func isQUICNetwork(network string) bool {
+	return network == NetworkUDP || network == NetworkUDP4 || network == NetworkUDP6
+}
+
+// dialQUIC establishes a QUIC connection over a UDP socket of its own, which is closed along with the connection.
+// As QUIC establishes a connection and the TLS handshake at once, the handshake is traced as TLS only.
+func (c *Client) dialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (quic.EarlyConnection, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.DialTimeout)
+	defer cancel()
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace == nil {
+		trace = &httptrace.ClientTrace{}
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	// "udp", "udp4" and "udp6" map to "ip", "ip4" and "ip6" respectively
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip"+strings.TrimPrefix(c.Network, NetworkUDP), host)
+	if trace.DNSDone != nil {
+		trace.DNSDone(httptrace.DNSDoneInfo{Err: err})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	udpAddr, err := net.ResolveUDPAddr(c.Network, net.JoinHostPort(ips[0].Unmap().String(), port))
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP(c.Network, nil)
+	if err != nil {
+		return nil, err
+	}
+	transport := &quic.Transport{Conn: udpConn}
+
+	if trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	conn, err := transport.DialEarly(ctx, udpAddr, tlsConfig, quicConfig)
+	if trace.TLSHandshakeDone != nil {
+		state := tls.ConnectionState{}
+		if conn != nil {
+			state = conn.ConnectionState().TLS
+		}
+		trace.TLSHandshakeDone(state, err)
+	}
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-conn.Context().Done()
+		transport.Close()
+	}()
+
+	return conn, nil
+}
+
+// CloseIdleConnections closes connections kept alive by the client
+func (c *Client) CloseIdleConnections() {
+	c.HTTPClient.CloseIdleConnections()
+}
+
+func (c *Client) downURL(size int64) string {
+	return fmt.Sprintf("%s%s?bytes=%d", strings.TrimSuffix(c.BaseURL, "/"), downPath, size)
+}
+
+func (c *Client) upURL() string {
+	return strings.TrimSuffix(c.BaseURL, "/") + upPath
+}
@@ -0,0 +1,142 @@
+package cfspeed
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewClient_IndependentTransports(t *testing.T) {
+	defaultTransport := http.DefaultTransport
+
+	server := httptest.NewServer(NewServer(&ServerOptions{}))
+	t.Cleanup(server.Close)
+
+	config := NewConfig()
+	config.BaseURL = server.URL
+
+	config.Network = NetworkTCP4
+	client4, err := NewClient(config)
+	assert.NilError(t, err)
+
+	config.Network = NetworkTCP6
+	client6, err := NewClient(config)
+	assert.NilError(t, err)
+
+	// the server only listens on an IPv4 address
+	chanErr6 := make(chan error, 1)
+	go func() {
+		_, err := client6.GetMeasurementMetadata(context.Background())
+		chanErr6 <- err
+	}()
+	_, err4 := client4.GetMeasurementMetadata(context.Background())
+
+	assert.NilError(t, err4)
+	assert.Assert(t, <-chanErr6 != nil)
+	assert.Assert(t, client4.HTTPClient.Transport != client6.HTTPClient.Transport)
+	assert.Equal(t, http.DefaultTransport, defaultTransport)
+}
+
+// startHTTP3Server serves over HTTP/3 on a UDP port of the loopback with the self-signed certificate of httptest
+func startHTTP3Server(t *testing.T) *Client {
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	tlsServer.Close()
+
+	udpConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NilError(t, err)
+
+	server := &http3.Server{
+		Handler:   NewServer(&ServerOptions{}),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: tlsServer.TLS.Certificates}),
+	}
+	go server.Serve(udpConn)
+	t.Cleanup(func() {
+		server.Close()
+		udpConn.Close()
+	})
+
+	config := NewConfig()
+	config.BaseURL = "https://" + udpConn.LocalAddr().String()
+	config.Network = NetworkUDP4
+	config.Insecure = true
+	config.Measurement.SpeedDuration = 500 * time.Millisecond
+
+	client, err := NewClient(config)
+	assert.NilError(t, err)
+	t.Cleanup(client.CloseIdleConnections)
+
+	return client
+}
+
+func TestClient_HTTP3(t *testing.T) {
+	client := startHTTP3Server(t)
+
+	metadata, err := client.GetMeasurementMetadata(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, metadata.SrcIP, "127.0.0.1")
+
+	// QUIC establishes a connection along with the TLS handshake
+	assert.Assert(t, metadata.Phases.TLS != nil && metadata.Phases.Connect == nil)
+
+	rtt, _, err := client.MeasureRTT(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, rtt.Phases.NReused, rtt.NSamples)
+
+	downlink, err := client.MeasureDownlink(context.Background(), 2)
+	assert.NilError(t, err)
+	assert.Assert(t, downlink.TXSize > 0)
+
+	uplink, err := client.MeasureUplink(context.Background(), 2)
+	assert.NilError(t, err)
+	assert.Assert(t, uplink.TXSize > 0)
+}
+
+func TestNewClient_InvalidConfig(t *testing.T) {
+	config := NewConfig()
+
+	config.BaseURL = "ftp://example.com"
+	_, err := NewClient(config)
+	assert.ErrorContains(t, err, "scheme")
+
+	config.BaseURL = DefaultBaseURL
+	config.Network = "unix"
+	_, err = NewClient(config)
+	assert.ErrorContains(t, err, `invalid network "unix"`)
+
+	config.BaseURL = "http://localhost:8080"
+	config.Network = NetworkUDP4
+	_, err = NewClient(config)
+	assert.ErrorContains(t, err, "HTTP/3 needs the scheme to be https")
+
+	config.BaseURL = DefaultBaseURL
+
+	config.Network = NetworkTCP
+	config.CACertFile = "/nonexistent/ca.pem"
+	_, err = NewClient(config)
+	assert.ErrorContains(t, err, "could not read CA certificates")
+}
+
+func TestMeasurementOptions_Validate(t *testing.T) {
+	assert.NilError(t, NewMeasurementOptions().Validate())
+
+	opts := NewMeasurementOptions()
+	opts.SpeedDuration = 60 * time.Second
+	assert.ErrorContains(t, opts.Validate(), `invalid run timeout "30s"; it needs to be longer than the speed measurement duration "1m0s"`)
+
+	opts.RunTimeout = 90 * time.Second
+	assert.NilError(t, opts.Validate())
+
+	opts.RTTCountMax = 0
+	assert.ErrorContains(t, opts.Validate(), `invalid maximum number of pings "0"`)
+
+	opts = NewMeasurementOptions()
+	opts.DownloadSizeMax = 0
+	assert.ErrorContains(t, opts.Validate(), `invalid maximum download size "0"`)
+}
@@ -0,0 +1,104 @@
+package cfspeed
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://speed.cloudflare.com"
+
+	downPath = "/__down"
+	upPath   = "/__up"
+)
+
+const (
+	NetworkTCP  = "tcp"
+	NetworkTCP4 = "tcp4"
+	NetworkTCP6 = "tcp6"
+
+	// Networks over which HTTP/3 is spoken on QUIC
+	NetworkUDP  = "udp"
+	NetworkUDP4 = "udp4"
+	NetworkUDP6 = "udp6"
+
+	DefaultDialTimeout = 10 * time.Second
+)
+
+// Config is the set of user-facing settings from which a Client is made
+type Config struct {
+	BaseURL     string        // Base URL of the speed test server, e.g. "https://speed.cloudflare.com"
+	Network     string        // Network to dial, i.e. "tcp", "tcp4" or "tcp6", or "udp", "udp4" or "udp6" for HTTP/3
+	DialTimeout time.Duration // Timeout of establishing a connection
+	CACertFile  string        // Path to a PEM bundle of CA certificates trusted in addition to the system ones
+	Insecure    bool          // Skip verification of the server certificate
+
+	Measurement MeasurementOptions // Bounds of measurements
+}
+
+func NewConfig() *Config {
+	return &Config{
+		BaseURL:     DefaultBaseURL,
+		Network:     NetworkTCP,
+		DialTimeout: DefaultDialTimeout,
+		Measurement: *NewMeasurementOptions(),
+	}
+}
+
+func (c *Config) Validate() error {
+	baseURL, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return fmt.Errorf(`invalid server URL "%s": %w`, c.BaseURL, err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return fmt.Errorf(`invalid server URL "%s"; the scheme needs to be either http or https`, c.BaseURL)
+	}
+	if baseURL.Host == "" {
+		return fmt.Errorf(`invalid server URL "%s"; the host is missing`, c.BaseURL)
+	}
+
+	switch c.Network {
+	case NetworkTCP, NetworkTCP4, NetworkTCP6:
+	case NetworkUDP, NetworkUDP4, NetworkUDP6:
+		if baseURL.Scheme != "https" {
+			return fmt.Errorf(`invalid server URL "%s"; HTTP/3 needs the scheme to be https`, c.BaseURL)
+		}
+	default:
+		return fmt.Errorf(`invalid network "%s"`, c.Network)
+	}
+
+	if c.DialTimeout <= 0 {
+		return fmt.Errorf(`invalid dial timeout "%s"; it needs to be positive`, c.DialTimeout)
+	}
+
+	return c.Measurement.Validate()
+}
+
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+	}
+
+	if c.CACertFile != "" {
+		pemCerts, err := os.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf(`could not read CA certificates: %w`, err)
+		}
+
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf(`no CA certificates found in "%s"`, c.CACertFile)
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,184 @@
+package cfspeed
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ConnPhases are durations of the phases of an HTTP request.
+// Phases establishing a connection are zero for requests over reused connections, and so is DNS for addresses given literally.
+type ConnPhases struct {
+	DNS     time.Duration `json:"dns"`
+	Connect time.Duration `json:"connect"`
+	TLS     time.Duration `json:"tls"`
+	TTFB    time.Duration `json:"ttfb"` // From the request written to the first byte of the response
+	Reused  bool          `json:"reused"`
+}
+
+// connTracer records ConnPhases of a request through httptrace
+type connTracer struct {
+	mutex  sync.Mutex
+	phases ConnPhases
+
+	dnsStart      time.Time
+	connectStarts map[string]time.Time // By address, as addresses may be dialled in parallel
+	tlsStart      time.Time
+	wroteRequest  time.Time
+}
+
+// withConnTracer attaches a new connTracer to the context of a request
+func withConnTracer(ctx context.Context) (context.Context, *connTracer) {
+	t := &connTracer{
+		connectStarts: map[string]time.Time{},
+	}
+
+	// callbacks regarding dials may be made from goroutines other than that of the request
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.phases.Reused = info.Reused
+		},
+		DNSStart: func(_ httptrace.DNSStartInfo) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			if info.Err == nil {
+				t.phases.DNS = time.Since(t.dnsStart)
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.connectStarts[network+" "+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			if err == nil {
+				t.phases.Connect = time.Since(t.connectStarts[network+" "+addr])
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			if err == nil {
+				t.phases.TLS = time.Since(t.tlsStart)
+			}
+		},
+		WroteRequest: func(_ httptrace.WroteRequestInfo) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.phases.TTFB = time.Since(t.wroteRequest)
+		},
+	}
+
+	return httptrace.WithClientTrace(ctx, trace), t
+}
+
+// getPhases takes a snapshot of the phases recorded so far
+func (t *connTracer) getPhases() *ConnPhases {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	phases := t.phases
+	return &phases
+}
+
+// ConnPhaseStats summarise phases of HTTP requests in ms.
+// Phases establishing connections are of those made afresh only, and nil if none were made.
+type ConnPhaseStats struct {
+	NRequests int    `json:"nRequests"`
+	NReused   int    `json:"nReused"` // Requests over reused connections
+	DNS       *Stats `json:"dns,omitempty"`
+	Connect   *Stats `json:"connect,omitempty"`
+	TLS       *Stats `json:"tls,omitempty"` // nil without TLS
+	TTFB      *Stats `json:"ttfb,omitempty"`
+}
+
+func getConnPhaseMSStats(durations []time.Duration) *Stats {
+	if len(durations) == 0 {
+		return nil
+	}
+
+	return getF64Stats(getDurationsMS(durations))
+}
+
+// getConnPhaseStats summarises phases of requests; requests of which phases were not recorded are left out
+func getConnPhaseStats(phasesList []*ConnPhases) *ConnPhaseStats {
+	var dnsDurs, connectDurs, tlsDurs, ttfbDurs []time.Duration
+	stats := &ConnPhaseStats{}
+
+	for _, phases := range phasesList {
+		if phases == nil {
+			continue
+		}
+
+		stats.NRequests += 1
+		if phases.Reused {
+			stats.NReused += 1
+		}
+		if phases.DNS > 0 {
+			dnsDurs = append(dnsDurs, phases.DNS)
+		}
+		if phases.Connect > 0 {
+			connectDurs = append(connectDurs, phases.Connect)
+		}
+		if phases.TLS > 0 {
+			tlsDurs = append(tlsDurs, phases.TLS)
+		}
+		ttfbDurs = append(ttfbDurs, phases.TTFB)
+	}
+
+	if stats.NRequests == 0 {
+		return nil
+	}
+
+	stats.DNS = getConnPhaseMSStats(dnsDurs)
+	stats.Connect = getConnPhaseMSStats(connectDurs)
+	stats.TLS = getConnPhaseMSStats(tlsDurs)
+	stats.TTFB = getConnPhaseMSStats(ttfbDurs)
+
+	return stats
+}
+
+type connPhaseStat struct {
+	name  string // Name in metrics and columns
+	label string // Label in text
+	stats *Stats
+}
+
+// getPhases lists phases summarised in the order they take place, leaving out those not recorded
+func (s *ConnPhaseStats) getPhases() []*connPhaseStat {
+	phases := []*connPhaseStat{}
+
+	for _, phase := range []*connPhaseStat{
+		{"dns", "DNS", s.DNS},
+		{"connect", "Connect", s.Connect},
+		{"tls", "TLS", s.TLS},
+		{"ttfb", "TTFB", s.TTFB},
+	} {
+		if phase.stats != nil {
+			phases = append(phases, phase)
+		}
+	}
+
+	return phases
+}
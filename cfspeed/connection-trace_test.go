@@ -0,0 +1,53 @@
+package cfspeed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestGetConnPhaseStats(t *testing.T) {
+	stats := getConnPhaseStats([]*ConnPhases{
+		{DNS: 2 * time.Millisecond, Connect: 10 * time.Millisecond, TLS: 20 * time.Millisecond, TTFB: 12 * time.Millisecond},
+		{TTFB: 10 * time.Millisecond, Reused: true},
+		nil,
+		{TTFB: 14 * time.Millisecond, Reused: true},
+	})
+
+	assert.Equal(t, stats.NRequests, 3)
+	assert.Equal(t, stats.NReused, 2)
+	assert.Equal(t, stats.DNS.Mean, 2.0)
+	assert.Equal(t, stats.Connect.NSamples, 1)
+	assert.Equal(t, stats.TLS.Mean, 20.0)
+	assert.Equal(t, stats.TTFB.Mean, 12.0)
+	assert.Equal(t, stats.getPhases()[3].name, "ttfb")
+
+	assert.Assert(t, getConnPhaseStats([]*ConnPhases{nil}) == nil)
+}
+
+func TestMeasureRTT_Phases(t *testing.T) {
+	client := startDummyServer(t)
+
+	stats, _, err := client.MeasureRTT(context.Background())
+	assert.NilError(t, err)
+
+	// probes after the first reuse its connection, made to a literal address without TLS
+	assert.Equal(t, stats.Phases.NRequests, stats.NSamples)
+	assert.Equal(t, stats.Phases.NReused, stats.NSamples-1)
+	assert.Equal(t, stats.Phases.Connect.NSamples, 1)
+	assert.Equal(t, stats.Phases.TTFB.NSamples, stats.NSamples)
+	assert.Assert(t, stats.Phases.DNS == nil && stats.Phases.TLS == nil)
+}
+
+func TestMeasureDownlink_Phases(t *testing.T) {
+	client := startDummyServer(t)
+	client.Measurement.SpeedDuration = 500 * time.Millisecond
+
+	stats, err := client.MeasureDownlink(context.Background(), 2)
+	assert.NilError(t, err)
+
+	assert.Assert(t, stats.Phases.NRequests >= 2)
+	assert.Equal(t, stats.Phases.Connect.NSamples, stats.Phases.NRequests-stats.Phases.NReused)
+}
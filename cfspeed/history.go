@@ -0,0 +1,285 @@
+package cfspeed
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const historyFileName = "history.jsonl"
+
+// HistoryRecord is a line of the history file
+type HistoryRecord struct {
+	SchemaVersion int `json:"schemaVersion"`
+	*RunResult
+}
+
+// HistoryStore appends results of runs to a JSON Lines file.
+// It is also a ResultWriter, so that it can be combined with other writers by NewMultiResultWriter.
+type HistoryStore struct {
+	Path string
+}
+
+type HistoryFilter struct {
+	Since    time.Time // Inclusive; zero for no limit
+	Until    time.Time // Exclusive; zero for no limit
+	Protocol string    // Empty for any
+	Colo     string    // Empty for any; case-insensitive
+}
+
+// DailySummary holds medians of the day; those of metrics measured by none of the runs of the day are nil
+type DailySummary struct {
+	Date              string   `json:"date"`
+	NRuns             int      `json:"nRuns"`
+	DownlinkMedian    *float64 `json:"downlinkMedian,omitempty"`    // Median of means of downlink speed in Mbps
+	UplinkMedian      *float64 `json:"uplinkMedian,omitempty"`      // Median of means of uplink speed in Mbps
+	UnloadedRTTMedian *float64 `json:"unloadedRTTMedian,omitempty"` // Median of means of unloaded RTT in ms
+}
+
+// HistoryTrend holds slopes of daily medians fitted by least squares; those of metrics summarised on fewer than 2 days are nil
+type HistoryTrend struct {
+	DownlinkPerDay    *float64 `json:"downlinkPerDay,omitempty"`
+	UplinkPerDay      *float64 `json:"uplinkPerDay,omitempty"`
+	UnloadedRTTPerDay *float64 `json:"unloadedRTTPerDay,omitempty"`
+}
+
+// DefaultHistoryPath returns $XDG_DATA_HOME/cfspeed/history.jsonl, falling back to ~/.local/share as per the XDG Base Directory Specification
+func DefaultHistoryPath() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(homeDir, ".local", "share")
+	}
+
+	return filepath.Join(dataHome, "cfspeed", historyFileName), nil
+}
+
+func (h *HistoryStore) WriteRun(run *RunResult) error {
+	line, err := json.Marshal(&HistoryRecord{
+		SchemaVersion: ResultSchemaVersion,
+		RunResult:     run,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(h.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	// a single write per record keeps concurrent appends from interleaving
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (h *HistoryStore) Close() error {
+	return nil
+}
+
+func (f *HistoryFilter) matches(run *RunResult) bool {
+	if !f.Since.IsZero() && run.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !run.Timestamp.Before(f.Until) {
+		return false
+	}
+	if f.Protocol != "" && run.TransportProtocol != f.Protocol {
+		return false
+	}
+	if f.Colo != "" && (run.Metadata == nil || !strings.EqualFold(run.Metadata.DstColo, f.Colo)) {
+		return false
+	}
+
+	return true
+}
+
+// Load reads the runs matching the filter in chronological order.
+// A missing file yields no runs, and malformed lines, e.g. ones truncated by a crash, are skipped, whereas records of unsupported schema versions are rejected.
+func (h *HistoryStore) Load(filter *HistoryFilter) ([]*RunResult, error) {
+	runs := []*RunResult{}
+
+	file, err := os.Open(h.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return runs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		record := &HistoryRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			continue
+		}
+		// records of other versions may mean otherwise, e.g. ones appended by a newer release
+		if record.SchemaVersion != ResultSchemaVersion {
+			return nil, fmt.Errorf("unsupported history schema version %d", record.SchemaVersion)
+		}
+
+		if record.RunResult != nil && filter.matches(record.RunResult) {
+			runs = append(runs, record.RunResult)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Timestamp.Before(runs[j].Timestamp)
+	})
+
+	return runs, nil
+}
+
+func getF64Median(series []float64) float64 {
+	if len(series) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(series))
+	copy(sorted, series)
+	sort.Float64s(sorted)
+
+	if len(sorted)%2 == 0 {
+		return (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+
+	return sorted[len(sorted)/2]
+}
+
+// getOptionalF64Median tells the median, or nil for an empty series rather than a misleading 0
+func getOptionalF64Median(series []float64) *float64 {
+	if len(series) == 0 {
+		return nil
+	}
+
+	median := getF64Median(series)
+	return &median
+}
+
+// SummariseHistory aggregates successful runs per day in the location given
+func SummariseHistory(runs []*RunResult, location *time.Location) []*DailySummary {
+	summaries := []*DailySummary{}
+	var current *DailySummary = nil
+	dlMeans, ulMeans, rttMeans := []float64{}, []float64{}, []float64{}
+
+	flush := func() {
+		if current != nil {
+			current.DownlinkMedian = getOptionalF64Median(dlMeans)
+			current.UplinkMedian = getOptionalF64Median(ulMeans)
+			current.UnloadedRTTMedian = getOptionalF64Median(rttMeans)
+			summaries = append(summaries, current)
+		}
+		dlMeans, ulMeans, rttMeans = []float64{}, []float64{}, []float64{}
+	}
+
+	for _, run := range runs {
+		if run.Error != "" {
+			continue
+		}
+
+		date := run.Timestamp.In(location).Format(time.DateOnly)
+		if current == nil || current.Date != date {
+			flush()
+			current = &DailySummary{
+				Date: date,
+			}
+		}
+
+		current.NRuns += 1
+		if run.Downlink != nil {
+			dlMeans = append(dlMeans, run.Downlink.Mean)
+		}
+		if run.Uplink != nil {
+			ulMeans = append(ulMeans, run.Uplink.Mean)
+		}
+		if run.UnloadedRTT != nil {
+			rttMeans = append(rttMeans, run.UnloadedRTT.Mean)
+		}
+	}
+	flush()
+
+	return summaries
+}
+
+func getLeastSquaresSlope(xs, ys []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+
+	xMean := getF64Mean(xs)
+	yMean := getF64Mean(ys)
+
+	numerator := float64(0)
+	denominator := float64(0)
+	for index := range xs {
+		numerator += (xs[index] - xMean) * (ys[index] - yMean)
+		denominator += (xs[index] - xMean) * (xs[index] - xMean)
+	}
+
+	if denominator == 0 {
+		return 0
+	}
+
+	return numerator / denominator
+}
+
+// getDailyTrend fits a line to the daily medians given, leaving out days without the median
+func getDailyTrend(summaries []*DailySummary, getMedian func(summary *DailySummary) *float64) *float64 {
+	days, medians := []float64{}, []float64{}
+
+	for _, summary := range summaries {
+		median := getMedian(summary)
+		if median == nil {
+			continue
+		}
+
+		date, err := time.Parse(time.DateOnly, summary.Date)
+		if err != nil {
+			continue
+		}
+
+		days = append(days, math.Floor(float64(date.Unix())/(24*60*60)))
+		medians = append(medians, *median)
+	}
+
+	if len(days) < 2 {
+		return nil
+	}
+
+	slope := getLeastSquaresSlope(days, medians)
+	return &slope
+}
+
+// GetHistoryTrend fits lines to the daily summaries, taking gaps between days into account
+func GetHistoryTrend(summaries []*DailySummary) *HistoryTrend {
+	return &HistoryTrend{
+		DownlinkPerDay:    getDailyTrend(summaries, func(summary *DailySummary) *float64 { return summary.DownlinkMedian }),
+		UplinkPerDay:      getDailyTrend(summaries, func(summary *DailySummary) *float64 { return summary.UplinkMedian }),
+		UnloadedRTTPerDay: getDailyTrend(summaries, func(summary *DailySummary) *float64 { return summary.UnloadedRTTMedian }),
+	}
+}
@@ -0,0 +1,155 @@
+package cfspeed
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func generateDummyHistoryRun(timestamp string, protocol string, colo string, dlMean float64) *RunResult {
+	parsed, _ := time.Parse(time.RFC3339, timestamp)
+
+	return &RunResult{
+		Timestamp:         parsed,
+		TransportProtocol: protocol,
+		Metadata: &MeasurementMetadata{
+			DstColo: colo,
+		},
+		UnloadedRTT: &Stats{Mean: 10},
+		Downlink:    &SpeedMeasurementStats{Mean: dlMean},
+		Uplink:      &SpeedMeasurementStats{Mean: dlMean / 10},
+	}
+}
+
+func TestHistoryStore(t *testing.T) {
+	historyStore := &HistoryStore{
+		Path: filepath.Join(t.TempDir(), "sub", historyFileName),
+	}
+
+	runs, err := historyStore.Load(&HistoryFilter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(runs), 0)
+
+	assert.NilError(t, historyStore.WriteRun(generateDummyHistoryRun("2024-04-02T00:00:00Z", "tcp4", "NRT", 200)))
+	assert.NilError(t, historyStore.WriteRun(generateDummyHistoryRun("2024-04-01T00:00:00Z", "tcp6", "KIX", 100)))
+
+	// a record truncated by a crash
+	file, err := os.OpenFile(historyStore.Path, os.O_WRONLY|os.O_APPEND, 0)
+	assert.NilError(t, err)
+	_, err = file.WriteString(`{"schemaVersion":1,"timestamp":"2024-04-0`)
+	assert.NilError(t, err)
+	assert.NilError(t, file.Close())
+
+	assert.NilError(t, historyStore.WriteRun(generateDummyHistoryRun("2024-04-03T00:00:00Z", "tcp4", "NRT", 300)))
+
+	runs, err = historyStore.Load(&HistoryFilter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(runs), 2)
+	assert.DeepEqual(t, runs[0], generateDummyHistoryRun("2024-04-01T00:00:00Z", "tcp6", "KIX", 100))
+	assert.DeepEqual(t, runs[1], generateDummyHistoryRun("2024-04-02T00:00:00Z", "tcp4", "NRT", 200))
+
+	runs, err = historyStore.Load(&HistoryFilter{Colo: "nrt"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(runs), 1)
+	assert.Equal(t, runs[0].Downlink.Mean, 200.0)
+
+	runs, err = historyStore.Load(&HistoryFilter{Protocol: "tcp6"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(runs), 1)
+	assert.Equal(t, runs[0].Downlink.Mean, 100.0)
+}
+
+func TestHistoryStore_UnsupportedVersion(t *testing.T) {
+	historyStore := &HistoryStore{
+		Path: filepath.Join(t.TempDir(), historyFileName),
+	}
+
+	assert.NilError(t, historyStore.WriteRun(generateDummyHistoryRun("2024-04-01T00:00:00Z", "tcp4", "NRT", 100)))
+	file, err := os.OpenFile(historyStore.Path, os.O_WRONLY|os.O_APPEND, 0)
+	assert.NilError(t, err)
+	_, err = file.WriteString(`{"schemaVersion":99,"timestamp":"2024-04-02T00:00:00Z"}` + "\n")
+	assert.NilError(t, err)
+	assert.NilError(t, file.Close())
+
+	_, err = historyStore.Load(&HistoryFilter{})
+	assert.ErrorContains(t, err, "unsupported history schema version 99")
+}
+
+func TestHistoryStore_Since(t *testing.T) {
+	historyStore := &HistoryStore{
+		Path: filepath.Join(t.TempDir(), historyFileName),
+	}
+
+	for _, run := range []*RunResult{
+		generateDummyHistoryRun("2024-04-01T00:00:00Z", "tcp", "NRT", 100),
+		generateDummyHistoryRun("2024-04-02T00:00:00Z", "tcp", "NRT", 200),
+		generateDummyHistoryRun("2024-04-03T00:00:00Z", "tcp", "NRT", 300),
+	} {
+		assert.NilError(t, historyStore.WriteRun(run))
+	}
+
+	since, _ := time.Parse(time.RFC3339, "2024-04-02T00:00:00Z")
+	until, _ := time.Parse(time.RFC3339, "2024-04-03T00:00:00Z")
+
+	runs, err := historyStore.Load(&HistoryFilter{Since: since, Until: until})
+	assert.NilError(t, err)
+	assert.Equal(t, len(runs), 1)
+	assert.Equal(t, runs[0].Downlink.Mean, 200.0)
+}
+
+func getF64Pointer(value float64) *float64 {
+	return &value
+}
+
+func TestSummariseHistory(t *testing.T) {
+	failedRun := generateDummyHistoryRun("2024-04-01T12:00:00Z", "tcp", "NRT", 0)
+	failedRun.Error = "dummy"
+
+	runs := []*RunResult{
+		generateDummyHistoryRun("2024-04-01T00:00:00Z", "tcp", "NRT", 100),
+		generateDummyHistoryRun("2024-04-01T06:00:00Z", "tcp", "NRT", 300),
+		generateDummyHistoryRun("2024-04-01T09:00:00Z", "tcp", "NRT", 110),
+		failedRun,
+		generateDummyHistoryRun("2024-04-03T00:00:00Z", "tcp", "NRT", 130),
+		generateDummyHistoryRun("2024-04-03T06:00:00Z", "tcp", "NRT", 150),
+	}
+
+	summaries := SummariseHistory(runs, time.UTC)
+
+	assert.DeepEqual(t, summaries, []*DailySummary{
+		{Date: "2024-04-01", NRuns: 3, DownlinkMedian: getF64Pointer(110), UplinkMedian: getF64Pointer(11), UnloadedRTTMedian: getF64Pointer(10)},
+		{Date: "2024-04-03", NRuns: 2, DownlinkMedian: getF64Pointer(140), UplinkMedian: getF64Pointer(14), UnloadedRTTMedian: getF64Pointer(10)},
+	})
+
+	trend := GetHistoryTrend(summaries)
+
+	assert.Equal(t, *trend.DownlinkPerDay, 15.0)
+	assert.Equal(t, *trend.UnloadedRTTPerDay, 0.0)
+}
+
+func TestSummariseHistory_MetricsNotMeasured(t *testing.T) {
+	pingRun := generateDummyHistoryRun("2024-04-02T00:00:00Z", "tcp", "NRT", 0)
+	pingRun.Downlink, pingRun.Uplink = nil, nil
+
+	runs := []*RunResult{
+		generateDummyHistoryRun("2024-04-01T00:00:00Z", "tcp", "NRT", 100),
+		pingRun,
+		generateDummyHistoryRun("2024-04-03T00:00:00Z", "tcp", "NRT", 120),
+	}
+
+	summaries := SummariseHistory(runs, time.UTC)
+
+	assert.Equal(t, len(summaries), 3)
+	assert.Assert(t, summaries[1].DownlinkMedian == nil && summaries[1].UplinkMedian == nil)
+	assert.Equal(t, *summaries[1].UnloadedRTTMedian, 10.0)
+
+	// the day of ping runs only is left out of trends of speed rather than taken for 0 Mbps
+	trend := GetHistoryTrend(summaries)
+	assert.Equal(t, *trend.DownlinkPerDay, 10.0)
+
+	trend = GetHistoryTrend(summaries[1:2])
+	assert.Assert(t, trend.DownlinkPerDay == nil && trend.UnloadedRTTPerDay == nil)
+}
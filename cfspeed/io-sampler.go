@@ -11,21 +11,23 @@ const (
 )
 
 type IOEvent struct {
-	Timestamp time.Time
-	Mode      string
-	Size      int
+	Timestamp time.Time `json:"timestamp"`
+	Mode      string    `json:"mode"`
+	Size      int       `json:"size"`
 }
 
 type IOSampler struct {
-	SizeRead    int64
-	SizeWritten int64
-	Events      []*IOEvent
+	SizeRead    int64      `json:"sizeRead"`
+	SizeWritten int64      `json:"sizeWritten"`
+	Events      []*IOEvent `json:"events"`
 }
 
 type SamplingReaderWriter struct {
 	IOSampler
 	Quota    int64
 	GoodThru time.Time
+	Counter  *ProgressCounter // Counter to report bytes transferred to; nil for none
+	Budget   *ByteBudget      // Budget bytes read are taken from; nil for none
 }
 
 func (r *SamplingReaderWriter) Read(p []byte) (int, error) {
@@ -40,6 +42,10 @@ func (r *SamplingReaderWriter) Read(p []byte) (int, error) {
 		size = 0
 		err = io.EOF
 	}
+	if granted := int(r.Budget.take(int64(size))); granted < size {
+		size = granted
+		err = io.EOF
+	}
 
 	r.Events = append(r.Events, &IOEvent{
 		Timestamp: time.Now(),
@@ -47,6 +53,7 @@ func (r *SamplingReaderWriter) Read(p []byte) (int, error) {
 		Size:      size,
 	})
 	r.SizeRead += int64(size)
+	r.Counter.add(size)
 
 	return size, err
 }
@@ -60,6 +67,7 @@ func (w *SamplingReaderWriter) Write(p []byte) (int, error) {
 		Size:      size,
 	})
 	w.SizeWritten = int64(size)
+	w.Counter.add(size)
 
 	var err error = nil
 	if w.SizeWritten > w.Quota || time.Since(w.GoodThru) > 0 {
@@ -1,6 +1,7 @@
 package cfspeed
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,53 +14,142 @@ const (
 	DirectionDownlink = "down"
 	DirectionUplink   = "up"
 
-	downURLTemplate = "https://speed.cloudflare.com/__down?bytes=%d"
-	upURLTemplate   = "https://speed.cloudflare.com/__up"
+	// Directions of measurements made simultaneously in bidirectional mode
+	DirectionBidirectionalDownlink = "bidirectional-down"
+	DirectionBidirectionalUplink   = "bidirectional-up"
 
-	rttMeasurementDurationMax = 2 * time.Second   // Maximum duration of RTT measurement
-	rttMeasurementMax         = 20                // Maximum number of pings to be made for RTT measurement
-	speedMeasurementDuration  = 10 * time.Second  // Download / Upload continues until exceeding this time duration
-	downloadSizeMax           = 512 * 1024 * 1024 // Maximum size of data to be downloaded; 512 MiB
-	uploadSizeMax             = 512 * 1024 * 1024 // Maximum size of data to be uploaded; 512 MiB
+	DefaultRTTDurationMax  = 2 * time.Second   // Maximum duration of RTT measurement
+	DefaultRTTCountMax     = 20                // Maximum number of pings to be made for RTT measurement
+	DefaultSpeedDuration   = 10 * time.Second  // Download / Upload continues until exceeding this time duration
+	DefaultDownloadSizeMax = 512 * 1024 * 1024 // Maximum size of data to be downloaded per request; 512 MiB
+	DefaultUploadSizeMax   = 512 * 1024 * 1024 // Maximum size of data to be uploaded per request; 512 MiB
+	DefaultRunTimeout      = 30 * time.Second  // Timeout of each phase of a run
+
+	DefaultResponsivenessDurationMax = 20 * time.Second // Maximum duration of responsiveness measurement
 )
 
+// MeasurementOptions bounds the measurements made by a Client
+type MeasurementOptions struct {
+	SpeedDuration   time.Duration // Download / Upload continues until exceeding this time duration
+	DownloadSizeMax int64         // Maximum size of data to be downloaded per request
+	UploadSizeMax   int64         // Maximum size of data to be uploaded per request
+	RTTDurationMax  time.Duration // Maximum duration of RTT measurement
+	RTTCountMax     int           // Maximum number of pings to be made for RTT measurement
+	RunTimeout      time.Duration // Timeout of each phase of a run
+	BytesMax        int64         // Budget of bytes transferred by speed measurements of a run; 0 for no limit
+
+	ResponsivenessDurationMax time.Duration // Maximum duration of responsiveness measurement, which ends earlier once stable
+}
+
+func NewMeasurementOptions() *MeasurementOptions {
+	return &MeasurementOptions{
+		SpeedDuration:   DefaultSpeedDuration,
+		DownloadSizeMax: DefaultDownloadSizeMax,
+		UploadSizeMax:   DefaultUploadSizeMax,
+		RTTDurationMax:  DefaultRTTDurationMax,
+		RTTCountMax:     DefaultRTTCountMax,
+		RunTimeout:      DefaultRunTimeout,
+
+		ResponsivenessDurationMax: DefaultResponsivenessDurationMax,
+	}
+}
+
+func (o *MeasurementOptions) Validate() error {
+	if o.SpeedDuration <= 0 {
+		return fmt.Errorf(`invalid speed measurement duration "%s"; it needs to be positive`, o.SpeedDuration)
+	}
+	if o.DownloadSizeMax <= 0 {
+		return fmt.Errorf(`invalid maximum download size "%d"; it needs to be positive`, o.DownloadSizeMax)
+	}
+	if o.UploadSizeMax <= 0 {
+		return fmt.Errorf(`invalid maximum upload size "%d"; it needs to be positive`, o.UploadSizeMax)
+	}
+	if o.RTTDurationMax <= 0 {
+		return fmt.Errorf(`invalid maximum RTT measurement duration "%s"; it needs to be positive`, o.RTTDurationMax)
+	}
+	if o.BytesMax < 0 {
+		return fmt.Errorf(`invalid data budget "%d"; it needs to be non-negative`, o.BytesMax)
+	}
+	if o.RTTCountMax < 1 {
+		return fmt.Errorf(`invalid maximum number of pings "%d"; it needs to be a positive integer`, o.RTTCountMax)
+	}
+	if o.ResponsivenessDurationMax < rpmWindow {
+		return fmt.Errorf(`invalid maximum responsiveness measurement duration "%s"; it needs to be at least %s`, o.ResponsivenessDurationMax, rpmWindow)
+	}
+
+	// transfers in flight at the end of speed measurements and loaded RTT measurements need to finish within phases
+	if o.RunTimeout <= o.SpeedDuration {
+		return fmt.Errorf(`invalid run timeout "%s"; it needs to be longer than the speed measurement duration "%s"`, o.RunTimeout, o.SpeedDuration)
+	}
+	if o.RunTimeout <= o.RTTDurationMax {
+		return fmt.Errorf(`invalid run timeout "%s"; it needs to be longer than the maximum RTT measurement duration "%s"`, o.RunTimeout, o.RTTDurationMax)
+	}
+
+	return nil
+}
+
 type MeasurementMetadata struct {
-	SrcIP      string
-	SrcASN     string
-	SrcCity    string
-	SrcCountry string
-	DstColo    string
+	SrcIP      string `json:"srcIP"`
+	SrcASN     string `json:"srcASN"`
+	SrcCity    string `json:"srcCity"`
+	SrcCountry string `json:"srcCountry"`
+	DstColo    string `json:"dstColo"`
+
+	// Phases of the request fetching metadata, which is the first of a run and hence establishes a connection
+	Phases *ConnPhaseStats `json:"phases,omitempty"`
 }
 
 type SpeedMeasurement struct {
-	Direction      string
-	Size           int64
-	Start          time.Time
-	End            time.Time
-	Duration       time.Duration
-	IOSampler      IOSampler
-	CFReqDur       time.Duration
-	HTTPRespHeader http.Header
+	Direction      string        `json:"direction"`
+	Size           int64         `json:"size"`
+	Start          time.Time     `json:"start"`
+	End            time.Time     `json:"end"`
+	Duration       time.Duration `json:"duration"`
+	IOSampler      IOSampler     `json:"ioSampler"`
+	CFReqDur       time.Duration `json:"cfReqDur"`
+	HTTPRespHeader http.Header   `json:"httpRespHeader"`
+	Phases         *ConnPhases   `json:"phases,omitempty"`
 }
 
 type SpeedMeasurementStats struct {
-	NSamples     int
-	TXSize       int64
-	Multiplicity int
-	Mean         float64
-	StdErr       float64
-	Min          float64
-	Max          float64
-	Deciles      []float64
-	CatSpeed     float64
+	NSamples     int       `json:"nSamples"`
+	TXSize       int64     `json:"txSize"`
+	Multiplicity int       `json:"multiplicity"`
+	Mean         float64   `json:"mean"`
+	StdErr       float64   `json:"stdErr"`
+	Min          float64   `json:"min"`
+	Max          float64   `json:"max"`
+	Deciles      []float64 `json:"deciles"`
+	CatSpeed     float64   `json:"catSpeed"`
+	Truncated    bool      `json:"truncated,omitempty"` // Whether the measurement was cut short by the data budget
+
+	Phases *ConnPhaseStats `json:"phases,omitempty"` // Phases of the requests transferring data
+
+	// Mbps samples from which the stats are derived; retained for exporters building histograms
+	Samples []float64 `json:"-"`
+
+	// Raw measurements per connection from which the stats are derived; retained only on request
+	Measurements [][]*SpeedMeasurement `json:"-"`
+	Multiplexed  bool                  `json:"-"`
 }
 
-func flushHTTPResponse(resp *http.Response, maxSize int64, flushUntil time.Time) (int64, *IOSampler, error) {
+func getCatSpeed(totalSize int64, totalDurationUS int64) float64 {
+	if totalDurationUS <= 0 {
+		return 0
+	}
+
+	return float64(8*totalSize) / float64(totalDurationUS)
+}
+
+// flushHTTPResponse drains the body of the response, telling the size drained even if it fails halfway
+func flushHTTPResponse(resp *http.Response, maxSize int64, flushUntil time.Time, counter *ProgressCounter) (int64, *IOSampler, error) {
 	drain := InitSamplingReaderWriter(maxSize, flushUntil)
+	drain.Counter = counter
 
 	flushedSize, err := io.Copy(drain, resp.Body)
 	if err != nil && !errors.Is(err, io.EOF) {
-		return 0, nil, err
+		resp.Body.Close()
+		return flushedSize, nil, err
 	}
 
 	err = resp.Body.Close()
@@ -78,15 +168,33 @@ func getCFReqDur(httpRespHeader *http.Header) time.Duration {
 	return cfReqDur
 }
 
-func doDownlinkMeasurement(maxSize int64, measureUntil time.Time) (*SpeedMeasurement, error) {
-	getURL := fmt.Sprintf(downURLTemplate, maxSize)
+func (c *Client) doDownlinkMeasurement(ctx context.Context, maxSize int64, measureUntil time.Time) (*SpeedMeasurement, error) {
+	budget := getByteBudget(ctx)
+
+	// the size requested is taken from the budget beforehand so that the server never sends more than the budget allows
+	maxSize = budget.getDownloadSize(maxSize)
+	if maxSize <= 0 {
+		return nil, errByteBudgetExhausted
+	}
+
+	ctx, tracer := withConnTracer(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.downURL(maxSize), nil)
+	if err != nil {
+		budget.refund(maxSize)
+		return nil, err
+	}
+
 	start := time.Now()
 
-	resp, err := http.Get(getURL)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
+		budget.refund(maxSize)
 		return nil, err
 	}
-	downloadedSize, ioSampler, err := flushHTTPResponse(resp, maxSize, measureUntil)
+	downloadedSize, ioSampler, err := flushHTTPResponse(resp, maxSize, measureUntil, getProgressCounter(ctx))
+	// bytes granted but not transferred, e.g. of a transfer failing halfway, are left for later transfers
+	budget.refund(maxSize - downloadedSize)
 	if err != nil {
 		return nil, err
 	}
@@ -102,23 +210,33 @@ func doDownlinkMeasurement(maxSize int64, measureUntil time.Time) (*SpeedMeasure
 		IOSampler:      *ioSampler,
 		CFReqDur:       getCFReqDur(&resp.Header),
 		HTTPRespHeader: resp.Header,
+		Phases:         tracer.getPhases(),
 	}, nil
 }
 
-func doUplinkMeasurement(maxSize int64, measureUntil time.Time) (*SpeedMeasurement, error) {
-	postURL := upURLTemplate
+func (c *Client) doUplinkMeasurement(ctx context.Context, maxSize int64, measureUntil time.Time) (*SpeedMeasurement, error) {
 	postBodyReader := InitSamplingReaderWriter(maxSize, measureUntil)
+	postBodyReader.Counter = getProgressCounter(ctx)
+	postBodyReader.Budget = getByteBudget(ctx)
+
+	ctx, tracer := withConnTracer(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.upURL(), postBodyReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
 
 	start := time.Now()
 
-	resp, err := http.Post(postURL, "application/octet-stream", postBodyReader)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	end := time.Now()
 
-	_, _, err = flushHTTPResponse(resp, 0, measureUntil)
+	_, _, err = flushHTTPResponse(resp, 0, measureUntil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -132,82 +250,125 @@ func doUplinkMeasurement(maxSize int64, measureUntil time.Time) (*SpeedMeasureme
 		IOSampler:      postBodyReader.IOSampler,
 		CFReqDur:       getCFReqDur(&resp.Header),
 		HTTPRespHeader: resp.Header,
+		Phases:         tracer.getPhases(),
 	}, nil
 }
 
-func doMeasureSpeed(measurementFunc func(_ int64, _ time.Time) (*SpeedMeasurement, error), txSizeMax int64) ([]*SpeedMeasurement, error) {
+type speedMeasurementFunc func(_ context.Context, _ int64, _ time.Time) (*SpeedMeasurement, error)
+
+func doMeasureSpeed(ctx context.Context, measurementFunc speedMeasurementFunc, txSizeMax int64, duration time.Duration) ([]*SpeedMeasurement, error) {
 	measurements := []*SpeedMeasurement{}
-	var err error = nil
 
-	for measureUntil := time.Now().Add(speedMeasurementDuration); time.Since(measureUntil) < 0; {
-		measurement, err := measurementFunc(txSizeMax, measureUntil)
+	budget := getByteBudget(ctx)
+
+	for measureUntil := time.Now().Add(duration); time.Since(measureUntil) < 0 && !budget.Truncated(); {
+		measurement, err := measurementFunc(ctx, txSizeMax, measureUntil)
 		if err != nil {
 			break
 		}
 		measurements = append(measurements, measurement)
 	}
 
-	return measurements, err
+	// failures of individual transfers are tolerated, but cancellation is not
+	return measurements, ctx.Err()
 }
 
-func measureSpeedSingle(measurementFunc func(_ int64, _ time.Time) (*SpeedMeasurement, error), txSizeMax int64) (*SpeedMeasurementStats, error) {
-	measurements, err := doMeasureSpeed(measurementFunc, txSizeMax)
-	stats, totalSize, totalDuration := getSingleSpeedMeasurementStats(measurements)
+// AnalyseSpeedMeasurements derives stats from raw measurements grouped by connection.
+// Measurements made without multiplexing are given as a single group with multiplexed being false.
+func AnalyseSpeedMeasurements(groupedMeasurements [][]*SpeedMeasurement, multiplexed bool) *SpeedMeasurementStats {
+	var stats *Stats
+	var totalSize, totalDuration int64
+	multiplicity := len(groupedMeasurements)
+
+	if multiplexed {
+		stats, totalSize, totalDuration = getMultiplexedSpeedMeasurementStats(groupedMeasurements)
+	} else {
+		measurements := []*SpeedMeasurement{}
+		for _, group := range groupedMeasurements {
+			measurements = append(measurements, group...)
+		}
+
+		stats, totalSize, totalDuration = getSingleSpeedMeasurementStats(measurements)
+		multiplicity = 1
+	}
+
+	phasesList := []*ConnPhases{}
+	for _, group := range groupedMeasurements {
+		for _, measurement := range group {
+			phasesList = append(phasesList, measurement.Phases)
+		}
+	}
 
 	return &SpeedMeasurementStats{
 		NSamples:     stats.NSamples,
 		TXSize:       totalSize,
-		Multiplicity: 1,
+		Multiplicity: multiplicity,
 		Mean:         stats.Mean,
 		StdErr:       stats.StdErr,
 		Min:          stats.Min,
 		Max:          stats.Max,
 		Deciles:      stats.Deciles,
-		CatSpeed:     float64(8*totalSize) / float64(totalDuration),
-	}, err
+		CatSpeed:     getCatSpeed(totalSize, totalDuration),
+		Phases:       getConnPhaseStats(phasesList),
+		Samples:      stats.Samples,
+		Measurements: groupedMeasurements,
+		Multiplexed:  multiplexed,
+	}
 }
 
-func measureSpeedMultiplexed(measurementFunc func(_ int64, _ time.Time) (*SpeedMeasurement, error), txSizeMax int64, multiplicity int) (*SpeedMeasurementStats, error) {
+func measureSpeedSingle(ctx context.Context, measurementFunc speedMeasurementFunc, txSizeMax int64, duration time.Duration) (*SpeedMeasurementStats, error) {
+	measurements, err := doMeasureSpeed(ctx, measurementFunc, txSizeMax, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	return AnalyseSpeedMeasurements([][]*SpeedMeasurement{measurements}, false), nil
+}
+
+func measureSpeedMultiplexed(ctx context.Context, measurementFunc speedMeasurementFunc, txSizeMax int64, duration time.Duration, multiplicity int) (*SpeedMeasurementStats, error) {
 	groupedMeasurements := make([][]*SpeedMeasurement, multiplicity)
-	groupsCompleted := 0
-	chanCompleted := make(chan error)
+	chanCompleted := make(chan error, multiplicity)
+	var firstErr error = nil
+
+	groupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	for iter := 0; iter < multiplicity; iter += 1 {
 		group := iter
 		go func() {
-			measurements, err := doMeasureSpeed(measurementFunc, txSizeMax)
+			measurements, err := doMeasureSpeed(groupCtx, measurementFunc, txSizeMax, duration)
 			groupedMeasurements[group] = measurements
 			chanCompleted <- err
 		}()
 	}
 
-	for ; groupsCompleted < multiplicity; groupsCompleted += 1 {
-		if err := <-chanCompleted; err != nil {
-			return nil, err
+	// wait for all the groups even after a failure so that no goroutine outlives this function
+	for groupsCompleted := 0; groupsCompleted < multiplicity; groupsCompleted += 1 {
+		if err := <-chanCompleted; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
 		}
 	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
 
-	stats, totalSize, longestSpan := getMultiplexedSpeedMeasurementStats(groupedMeasurements)
-
-	return &SpeedMeasurementStats{
-		NSamples:     stats.NSamples,
-		TXSize:       totalSize,
-		Multiplicity: multiplicity,
-		Mean:         stats.Mean,
-		StdErr:       stats.StdErr,
-		Min:          stats.Min,
-		Max:          stats.Max,
-		Deciles:      stats.Deciles,
-		CatSpeed:     float64(8*totalSize) / float64(longestSpan),
-	}, nil
+	return AnalyseSpeedMeasurements(groupedMeasurements, true), nil
 }
 
-func GetMeasurementMetadata() (*MeasurementMetadata, error) {
-	resp, err := http.Get(fmt.Sprintf(downURLTemplate, 0))
+func (c *Client) GetMeasurementMetadata(ctx context.Context) (*MeasurementMetadata, error) {
+	ctx, tracer := withConnTracer(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.downURL(0), nil)
 	if err != nil {
 		return nil, err
 	}
-	_, _, err = flushHTTPResponse(resp, 0, time.Now())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = flushHTTPResponse(resp, 0, time.Now(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -228,15 +389,22 @@ func GetMeasurementMetadata() (*MeasurementMetadata, error) {
 		SrcCity:    srcCity,
 		SrcCountry: srcCountry,
 		DstColo:    resp.Header.Get("cf-meta-colo"),
+		Phases:     getConnPhaseStats([]*ConnPhases{tracer.getPhases()}),
 	}, nil
 }
 
-func MeasureRTT() (*Stats, *Stats, error) {
+func (c *Client) MeasureRTT(ctx context.Context) (*Stats, *Stats, error) {
+	return c.measureRTT(ctx, c.Measurement.RTTDurationMax)
+}
+
+// measureRTT pings until durationMax elapses or RTTCountMax pings are made
+func (c *Client) measureRTT(ctx context.Context, durationMax time.Duration) (*Stats, *Stats, error) {
 	durations := []time.Duration{}
 	cfReqDurs := []time.Duration{}
+	phasesList := []*ConnPhases{}
 
-	for measureUntil := time.Now().Add(rttMeasurementDurationMax); time.Since(measureUntil) < 0 && len(durations) < rttMeasurementMax; {
-		measurement, err := doUplinkMeasurement(0, time.Now())
+	for measureUntil := time.Now().Add(durationMax); time.Since(measureUntil) < 0 && len(durations) < c.Measurement.RTTCountMax; {
+		measurement, err := c.doUplinkMeasurement(ctx, 0, time.Now())
 		if err != nil {
 			return nil, nil, err
 		}
@@ -250,23 +418,65 @@ func MeasureRTT() (*Stats, *Stats, error) {
 		}
 
 		durations = append(durations, adjustedDuration)
+		phasesList = append(phasesList, measurement.Phases)
 	}
 
-	return getDurationMSStats(durations), getDurationMSStats(cfReqDurs), nil
-}
+	stats := getDurationMSStats(durations)
+	stats.Phases = getConnPhaseStats(phasesList)
 
-func MeasureDownlink() (*SpeedMeasurementStats, error) {
-	return measureSpeedSingle(doDownlinkMeasurement, downloadSizeMax)
+	return stats, getDurationMSStats(cfReqDurs), nil
 }
 
-func MeasureDownlinkMultiplexed(multiplicity int) (*SpeedMeasurementStats, error) {
-	return measureSpeedMultiplexed(doDownlinkMeasurement, downloadSizeMax, multiplicity)
+// MeasureDownlink measures downlink speed over the number of connections in parallel given.
+// Multiplicity less than 1 denotes a single connection, which is analysed without multiplexing.
+func (c *Client) MeasureDownlink(ctx context.Context, multiplicity int) (*SpeedMeasurementStats, error) {
+	if multiplicity > 0 {
+		return measureSpeedMultiplexed(ctx, c.doDownlinkMeasurement, c.Measurement.DownloadSizeMax, c.Measurement.SpeedDuration, multiplicity)
+	}
+
+	return measureSpeedSingle(ctx, c.doDownlinkMeasurement, c.Measurement.DownloadSizeMax, c.Measurement.SpeedDuration)
 }
 
-func MeasureUplink() (*SpeedMeasurementStats, error) {
-	return measureSpeedSingle(doUplinkMeasurement, uploadSizeMax)
+// MeasureUplink measures uplink speed in the same manner as MeasureDownlink
+func (c *Client) MeasureUplink(ctx context.Context, multiplicity int) (*SpeedMeasurementStats, error) {
+	if multiplicity > 0 {
+		return measureSpeedMultiplexed(ctx, c.doUplinkMeasurement, c.Measurement.UploadSizeMax, c.Measurement.SpeedDuration, multiplicity)
+	}
+
+	return measureSpeedSingle(ctx, c.doUplinkMeasurement, c.Measurement.UploadSizeMax, c.Measurement.SpeedDuration)
 }
 
-func MeasureUplinkMultiplexed(multiplicity int) (*SpeedMeasurementStats, error) {
-	return measureSpeedMultiplexed(doUplinkMeasurement, uploadSizeMax, multiplicity)
+// MeasureBidirectional measures downlink and uplink speed simultaneously over the same window,
+// each over the number of connections in parallel given in the same manner as MeasureDownlink.
+func (c *Client) MeasureBidirectional(ctx context.Context, multiplicity int) (*SpeedMeasurementStats, *SpeedMeasurementStats, error) {
+	var dlStats, ulStats *SpeedMeasurementStats = nil, nil
+	chanCompleted := make(chan error, 2)
+	var firstErr error = nil
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		var err error = nil
+		dlStats, err = c.MeasureDownlink(ctx, multiplicity)
+		chanCompleted <- err
+	}()
+	go func() {
+		var err error = nil
+		ulStats, err = c.MeasureUplink(ctx, multiplicity)
+		chanCompleted <- err
+	}()
+
+	// a failure of either direction ends the other, which is waited for so that no goroutine outlives this function
+	for directionsCompleted := 0; directionsCompleted < 2; directionsCompleted += 1 {
+		if err := <-chanCompleted; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+
+	return dlStats, ulStats, nil
 }
@@ -0,0 +1,57 @@
+package cfspeed
+
+import (
+	"context"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func testSpeedMeasurementCancellation(t *testing.T, measureFunc func(*Client, context.Context, int) (*SpeedMeasurementStats, error)) {
+	nGoroutinesBefore := runtime.NumGoroutine()
+
+	server := httptest.NewServer(NewServer(&ServerOptions{}))
+	config := NewConfig()
+	config.BaseURL = server.URL
+
+	client, err := NewClient(config)
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	stats, err := measureFunc(client, ctx, 4)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Assert(t, stats == nil)
+	assert.Assert(t, time.Since(start) < DefaultSpeedDuration/2)
+
+	server.Close()
+	client.CloseIdleConnections()
+
+	// connections may take a moment to be torn down
+	for waitUntil := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > nGoroutinesBefore && time.Since(waitUntil) < 0; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Assert(t, runtime.NumGoroutine() <= nGoroutinesBefore)
+}
+
+func TestMeasureDownlink_Cancellation(t *testing.T) {
+	testSpeedMeasurementCancellation(t, (*Client).MeasureDownlink)
+}
+
+func TestMeasureUplink_Cancellation(t *testing.T) {
+	testSpeedMeasurementCancellation(t, (*Client).MeasureUplink)
+}
+
+func TestMeasureBidirectional_Cancellation(t *testing.T) {
+	testSpeedMeasurementCancellation(t, func(client *Client, ctx context.Context, multiplicity int) (*SpeedMeasurementStats, error) {
+		dlStats, ulStats, err := client.MeasureBidirectional(ctx, multiplicity)
+		assert.Assert(t, ulStats == nil)
+		return dlStats, err
+	})
+}
@@ -0,0 +1,266 @@
+package cfspeed
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const csvNDeciles = 9
+
+// csvResultWriter renders every run as a row of a CSV table.
+// The header is fixed regardless of which phases were run so that rows from different runs line up; absent values are left empty.
+type csvResultWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func newCSVResultWriter(w io.Writer) *csvResultWriter {
+	return &csvResultWriter{
+		w: csv.NewWriter(w),
+	}
+}
+
+// csvColumn is a column of the CSV table along with how its field is given by a run
+type csvColumn struct {
+	name     string
+	getField func(run *RunResult) string
+}
+
+// newCSVColumn makes a column of a value of the part of the run given by getPart, leaving the field empty if the part is absent
+func newCSVColumn[T any](name string, getPart func(run *RunResult) *T, format func(part *T) string) *csvColumn {
+	return &csvColumn{
+		name: name,
+		getField: func(run *RunResult) string {
+			part := getPart(run)
+			if part == nil {
+				return ""
+			}
+
+			return format(part)
+		},
+	}
+}
+
+func formatCSVFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatCSVMean gives the mean of stats, which may be absent
+func formatCSVMean(stats *Stats) string {
+	if stats == nil {
+		return ""
+	}
+
+	return formatCSVFloat(stats.Mean)
+}
+
+func formatCSVDecile(deciles []float64, index int) string {
+	if index >= len(deciles) {
+		return ""
+	}
+
+	return formatCSVFloat(deciles[index])
+}
+
+// getCSVConnPhasesColumns lists columns of phases of requests, which are given by their means
+func getCSVConnPhasesColumns(prefix string, getPhases func(run *RunResult) *ConnPhaseStats) []*csvColumn {
+	return []*csvColumn{
+		newCSVColumn(prefix+".dns", getPhases, func(phases *ConnPhaseStats) string { return formatCSVMean(phases.DNS) }),
+		newCSVColumn(prefix+".connect", getPhases, func(phases *ConnPhaseStats) string { return formatCSVMean(phases.Connect) }),
+		newCSVColumn(prefix+".tls", getPhases, func(phases *ConnPhaseStats) string { return formatCSVMean(phases.TLS) }),
+		newCSVColumn(prefix+".ttfb", getPhases, func(phases *ConnPhaseStats) string { return formatCSVMean(phases.TTFB) }),
+		newCSVColumn(prefix+".requests", getPhases, func(phases *ConnPhaseStats) string { return strconv.Itoa(phases.NRequests) }),
+		newCSVColumn(prefix+".requestsReused", getPhases, func(phases *ConnPhaseStats) string { return strconv.Itoa(phases.NReused) }),
+	}
+}
+
+func getCSVMetadataColumns() []*csvColumn {
+	getMetadata := func(run *RunResult) *MeasurementMetadata { return run.Metadata }
+
+	columns := []*csvColumn{
+		newCSVColumn("srcIP", getMetadata, func(metadata *MeasurementMetadata) string { return metadata.SrcIP }),
+		newCSVColumn("srcASN", getMetadata, func(metadata *MeasurementMetadata) string { return metadata.SrcASN }),
+		newCSVColumn("srcCity", getMetadata, func(metadata *MeasurementMetadata) string { return metadata.SrcCity }),
+		newCSVColumn("srcCountry", getMetadata, func(metadata *MeasurementMetadata) string { return metadata.SrcCountry }),
+		newCSVColumn("dstColo", getMetadata, func(metadata *MeasurementMetadata) string { return metadata.DstColo }),
+	}
+
+	return append(columns, getCSVConnPhasesColumns("metadata.phases", func(run *RunResult) *ConnPhaseStats {
+		if run.Metadata == nil {
+			return nil
+		}
+		return run.Metadata.Phases
+	})...)
+}
+
+func getCSVRTTColumns(prefix string, getStats func(run *RunResult) *Stats) []*csvColumn {
+	columns := []*csvColumn{
+		newCSVColumn(prefix+".nSamples", getStats, func(stats *Stats) string { return strconv.Itoa(stats.NSamples) }),
+		newCSVColumn(prefix+".mean", getStats, func(stats *Stats) string { return formatCSVFloat(stats.Mean) }),
+		newCSVColumn(prefix+".stdErr", getStats, func(stats *Stats) string { return formatCSVFloat(stats.StdErr) }),
+		newCSVColumn(prefix+".min", getStats, func(stats *Stats) string { return formatCSVFloat(stats.Min) }),
+		newCSVColumn(prefix+".max", getStats, func(stats *Stats) string { return formatCSVFloat(stats.Max) }),
+	}
+	for index := 0; index < csvNDeciles; index += 1 {
+		columns = append(columns, newCSVColumn(fmt.Sprintf("%s.d%d", prefix, index+1), getStats, func(stats *Stats) string { return formatCSVDecile(stats.Deciles, index) }))
+	}
+
+	columns = append(columns, newCSVColumn(prefix+".jitter", getStats, func(stats *Stats) string { return formatCSVFloat(stats.Jitter) }))
+
+	return append(columns, getCSVConnPhasesColumns(prefix+".phases", func(run *RunResult) *ConnPhaseStats {
+		if stats := getStats(run); stats != nil {
+			return stats.Phases
+		}
+		return nil
+	})...)
+}
+
+func getCSVSpeedColumns(prefix string, getStats func(run *RunResult) *SpeedMeasurementStats) []*csvColumn {
+	columns := []*csvColumn{
+		newCSVColumn(prefix+".nSamples", getStats, func(stats *SpeedMeasurementStats) string { return strconv.Itoa(stats.NSamples) }),
+		newCSVColumn(prefix+".txSize", getStats, func(stats *SpeedMeasurementStats) string { return strconv.FormatInt(stats.TXSize, 10) }),
+		newCSVColumn(prefix+".multiplicity", getStats, func(stats *SpeedMeasurementStats) string { return strconv.Itoa(stats.Multiplicity) }),
+		newCSVColumn(prefix+".mean", getStats, func(stats *SpeedMeasurementStats) string { return formatCSVFloat(stats.Mean) }),
+		newCSVColumn(prefix+".stdErr", getStats, func(stats *SpeedMeasurementStats) string { return formatCSVFloat(stats.StdErr) }),
+		newCSVColumn(prefix+".min", getStats, func(stats *SpeedMeasurementStats) string { return formatCSVFloat(stats.Min) }),
+		newCSVColumn(prefix+".max", getStats, func(stats *SpeedMeasurementStats) string { return formatCSVFloat(stats.Max) }),
+	}
+	for index := 0; index < csvNDeciles; index += 1 {
+		columns = append(columns, newCSVColumn(fmt.Sprintf("%s.d%d", prefix, index+1), getStats, func(stats *SpeedMeasurementStats) string { return formatCSVDecile(stats.Deciles, index) }))
+	}
+
+	columns = append(columns,
+		newCSVColumn(prefix+".catSpeed", getStats, func(stats *SpeedMeasurementStats) string { return formatCSVFloat(stats.CatSpeed) }),
+		newCSVColumn(prefix+".truncated", getStats, func(stats *SpeedMeasurementStats) string { return strconv.FormatBool(stats.Truncated) }),
+	)
+
+	return append(columns, getCSVConnPhasesColumns(prefix+".phases", func(run *RunResult) *ConnPhaseStats {
+		if stats := getStats(run); stats != nil {
+			return stats.Phases
+		}
+		return nil
+	})...)
+}
+
+// getCSVResponsivenessColumns lists columns of responsiveness, of which latency components are given by their means
+func getCSVResponsivenessColumns(prefix string) []*csvColumn {
+	getStats := func(run *RunResult) *ResponsivenessStats { return run.Responsiveness }
+
+	return []*csvColumn{
+		newCSVColumn(prefix+".rpm", getStats, func(stats *ResponsivenessStats) string { return formatCSVFloat(stats.RPM) }),
+		newCSVColumn(prefix+".confidence", getStats, func(stats *ResponsivenessStats) string { return stats.Confidence }),
+		newCSVColumn(prefix+".connections", getStats, func(stats *ResponsivenessStats) string { return strconv.Itoa(stats.Connections) }),
+		newCSVColumn(prefix+".downlinkMbps", getStats, func(stats *ResponsivenessStats) string { return formatCSVFloat(stats.DownlinkMBPS) }),
+		newCSVColumn(prefix+".uplinkMbps", getStats, func(stats *ResponsivenessStats) string { return formatCSVFloat(stats.UplinkMBPS) }),
+		newCSVColumn(prefix+".foreignTCP", getStats, func(stats *ResponsivenessStats) string { return formatCSVMean(stats.ForeignTCP) }),
+		newCSVColumn(prefix+".foreignTLS", getStats, func(stats *ResponsivenessStats) string { return formatCSVMean(stats.ForeignTLS) }),
+		newCSVColumn(prefix+".foreignHTTP", getStats, func(stats *ResponsivenessStats) string { return formatCSVMean(stats.ForeignHTTP) }),
+		newCSVColumn(prefix+".selfHTTP", getStats, func(stats *ResponsivenessStats) string { return formatCSVMean(stats.SelfHTTP) }),
+		newCSVColumn(prefix+".truncated", getStats, func(stats *ResponsivenessStats) string { return strconv.FormatBool(stats.Truncated) }),
+	}
+}
+
+// getCSVScoresColumns lists columns of scores, with a rating per use case
+func getCSVScoresColumns(prefix string) []*csvColumn {
+	getScores := func(run *RunResult) *Scores { return run.Scores }
+
+	columns := []*csvColumn{
+		newCSVColumn(prefix+".bufferbloat", getScores, func(scores *Scores) string { return scores.Bufferbloat }),
+		newCSVColumn(prefix+".latencyIncrease", getScores, func(scores *Scores) string {
+			if scores.Bufferbloat == "" {
+				return ""
+			}
+			return formatCSVFloat(scores.LatencyIncrease)
+		}),
+	}
+	for _, useCase := range useCases {
+		columns = append(columns, newCSVColumn(prefix+"."+useCase, getScores, func(scores *Scores) string { return scores.UseCases[useCase] }))
+	}
+
+	return columns
+}
+
+// getCSVColumns lists the columns of the CSV table, grouped by phase in the order of runs
+func getCSVColumns() []*csvColumn {
+	columns := []*csvColumn{
+		{name: "timestamp", getField: func(run *RunResult) string { return run.Timestamp.Format(time.RFC3339Nano) }},
+		{name: "transportProtocol", getField: func(run *RunResult) string { return run.TransportProtocol }},
+	}
+
+	columns = append(columns, getCSVMetadataColumns()...)
+	columns = append(columns, getCSVRTTColumns("unloadedRTT", func(run *RunResult) *Stats { return run.UnloadedRTT })...)
+	columns = append(columns, getCSVSpeedColumns("downlink", func(run *RunResult) *SpeedMeasurementStats { return run.Downlink })...)
+	columns = append(columns, getCSVRTTColumns("downlinkLoadedRTT", func(run *RunResult) *Stats { return run.DownlinkLoadedRTT })...)
+	columns = append(columns, getCSVSpeedColumns("uplink", func(run *RunResult) *SpeedMeasurementStats { return run.Uplink })...)
+	columns = append(columns, getCSVRTTColumns("uplinkLoadedRTT", func(run *RunResult) *Stats { return run.UplinkLoadedRTT })...)
+	columns = append(columns, getCSVSpeedColumns("bidirectionalDownlink", func(run *RunResult) *SpeedMeasurementStats { return run.BidirectionalDownlink })...)
+	columns = append(columns, getCSVSpeedColumns("bidirectionalUplink", func(run *RunResult) *SpeedMeasurementStats { return run.BidirectionalUplink })...)
+	columns = append(columns, getCSVRTTColumns("bidirectionalLoadedRTT", func(run *RunResult) *Stats { return run.BidirectionalLoadedRTT })...)
+	columns = append(columns, getCSVResponsivenessColumns("responsiveness")...)
+	columns = append(columns, getCSVScoresColumns("scores")...)
+
+	return append(columns,
+		&csvColumn{name: "checksPassed", getField: func(run *RunResult) string {
+			if len(run.Checks) == 0 {
+				return ""
+			}
+			return strconv.FormatBool(run.ChecksPassed())
+		}},
+		&csvColumn{name: "warnings", getField: func(run *RunResult) string { return strings.Join(run.Warnings, "; ") }},
+		&csvColumn{name: "error", getField: func(run *RunResult) string { return run.Error }},
+	)
+}
+
+var csvColumns = getCSVColumns()
+
+func getCSVHeader() []string {
+	header := make([]string, len(csvColumns))
+	for index, column := range csvColumns {
+		header[index] = column.name
+	}
+
+	return header
+}
+
+func getCSVRecord(run *RunResult) []string {
+	record := make([]string, len(csvColumns))
+	for index, column := range csvColumns {
+		record[index] = column.getField(run)
+	}
+
+	return record
+}
+
+func (c *csvResultWriter) WriteRun(run *RunResult) error {
+	if !c.headerWritten {
+		if err := c.w.Write(getCSVHeader()); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+
+	if err := c.w.Write(getCSVRecord(run)); err != nil {
+		return err
+	}
+
+	// rows are flushed one by one so that the output can be followed while runs repeat
+	c.w.Flush()
+
+	return c.w.Error()
+}
+
+func (c *csvResultWriter) Close() error {
+	if !c.headerWritten {
+		if err := c.w.Write(getCSVHeader()); err != nil {
+			return err
+		}
+	}
+
+	c.w.Flush()
+
+	return c.w.Error()
+}
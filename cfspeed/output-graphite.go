@@ -0,0 +1,96 @@
+package cfspeed
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultGraphitePrefix = "cfspeed"
+
+// GraphiteOptions locates a Graphite plaintext protocol listener
+type GraphiteOptions struct {
+	Address string
+	Prefix  string
+}
+
+func (o *GraphiteOptions) Validate() error {
+	if _, _, err := net.SplitHostPort(o.Address); err != nil {
+		return fmt.Errorf(`invalid Graphite address "%s"; it needs to be in the form of host:port`, o.Address)
+	}
+	if strings.ContainsAny(o.Prefix, " ;~") {
+		return fmt.Errorf(`invalid Graphite prefix "%s"; it cannot contain spaces, semicolons or tildes`, o.Prefix)
+	}
+
+	return nil
+}
+
+// tag values may contain neither semicolons nor tildes, and nothing in a line may contain spaces
+var graphiteTagEscaper = strings.NewReplacer(";", "_", "~", "_", " ", "_", "=", "_")
+
+// encodeGraphiteLines renders a point in the Graphite plaintext protocol with tags, one line per field
+func encodeGraphiteLines(prefix string, point *sinkPoint) []string {
+	tags := &strings.Builder{}
+	for _, tag := range point.tags {
+		// empty tag values are not permitted
+		if tag[1] == "" {
+			continue
+		}
+		fmt.Fprintf(tags, ";%s=%s", graphiteTagEscaper.Replace(tag[0]), graphiteTagEscaper.Replace(tag[1]))
+	}
+
+	name := point.name
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+
+	lines := []string{}
+	for _, field := range point.fields {
+		lines = append(lines, fmt.Sprintf("%s.%s%s %s %d", name, field.key, tags.String(), strconv.FormatFloat(field.value, 'g', -1, 64), point.timestamp.Unix()))
+	}
+
+	return lines
+}
+
+func getGraphiteSendFunc(opts *GraphiteOptions) sinkSendFunc {
+	return func(lines []string, timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", opts.Address)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+
+		if _, err := conn.Write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+}
+
+// NewGraphiteResultWriter pushes runs to a Graphite plaintext protocol listener over TCP.
+// Series are named <prefix>.run.*, <prefix>.rtt.* and <prefix>.speed.*, tagged with protocol, colo, asn and country.
+func NewGraphiteResultWriter(ctx context.Context, opts *GraphiteOptions, sinkOpts *SinkOptions) (ResultWriter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := sinkOpts.Validate(); err != nil {
+		return nil, err
+	}
+
+	encode := getSinkPointsEncodeFunc(func(point *sinkPoint) []string {
+		return encodeGraphiteLines(opts.Prefix, point)
+	})
+
+	return newSinkResultWriter(ctx, "Graphite", encode, getGraphiteSendFunc(opts), sinkOpts), nil
+}
@@ -0,0 +1,105 @@
+package cfspeed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const influxMeasurementPrefix = "cfspeed_"
+
+// InfluxOptions locates an InfluxDB v2 write endpoint
+type InfluxOptions struct {
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+}
+
+func (o *InfluxOptions) Validate() error {
+	parsedURL, err := url.Parse(o.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return fmt.Errorf(`invalid InfluxDB URL "%s"; it needs to be an absolute HTTP(S) URL`, o.URL)
+	}
+	if o.Bucket == "" {
+		return fmt.Errorf("InfluxDB bucket needs to be specified")
+	}
+
+	return nil
+}
+
+func (o *InfluxOptions) writeURL() string {
+	query := url.Values{}
+	query.Set("bucket", o.Bucket)
+	query.Set("precision", "ns")
+	if o.Org != "" {
+		query.Set("org", o.Org)
+	}
+
+	return strings.TrimSuffix(o.URL, "/") + "/api/v2/write?" + query.Encode()
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+// encodeInfluxLines renders a point in the InfluxDB line protocol, which takes a single line
+func encodeInfluxLines(point *sinkPoint) []string {
+	line := &strings.Builder{}
+
+	line.WriteString(influxMeasurementEscaper.Replace(influxMeasurementPrefix + point.name))
+	for _, tag := range point.tags {
+		// empty tag values are not permitted
+		if tag[1] == "" {
+			continue
+		}
+		fmt.Fprintf(line, ",%s=%s", influxTagEscaper.Replace(tag[0]), influxTagEscaper.Replace(tag[1]))
+	}
+
+	for index, field := range point.fields {
+		separator := ","
+		if index == 0 {
+			separator = " "
+		}
+
+		value := strconv.FormatFloat(field.value, 'g', -1, 64)
+		if field.integer {
+			value = strconv.FormatInt(int64(field.value), 10) + "i"
+		}
+
+		fmt.Fprintf(line, "%s%s=%s", separator, influxTagEscaper.Replace(field.key), value)
+	}
+
+	fmt.Fprintf(line, " %d", point.timestamp.UnixNano())
+
+	return []string{line.String()}
+}
+
+func getInfluxSendFunc(opts *InfluxOptions, httpClient *http.Client) sinkSendFunc {
+	writeURL := opts.writeURL()
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	if opts.Token != "" {
+		header.Set("Authorization", "Token "+opts.Token)
+	}
+
+	return func(lines []string, timeout time.Duration) error {
+		return postSinkPayload(httpClient, writeURL, header, []byte(strings.Join(lines, "\n")+"\n"), timeout)
+	}
+}
+
+// NewInfluxResultWriter pushes runs to an InfluxDB v2 write endpoint in the line protocol.
+// Measurements are cfspeed_run, cfspeed_rtt and cfspeed_speed, tagged with protocol, colo, asn and country.
+func NewInfluxResultWriter(ctx context.Context, opts *InfluxOptions, sinkOpts *SinkOptions) (ResultWriter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := sinkOpts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return newSinkResultWriter(ctx, "InfluxDB", getSinkPointsEncodeFunc(encodeInfluxLines), getInfluxSendFunc(opts, &http.Client{}), sinkOpts), nil
+}
@@ -0,0 +1,30 @@
+package cfspeed
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type jsonResultWriter struct {
+	w      io.Writer
+	report *Report
+}
+
+func newJSONResultWriter(w io.Writer) *jsonResultWriter {
+	return &jsonResultWriter{
+		w:      w,
+		report: NewReport(),
+	}
+}
+
+func (j *jsonResultWriter) WriteRun(run *RunResult) error {
+	j.report.Runs = append(j.report.Runs, run)
+	return nil
+}
+
+func (j *jsonResultWriter) Close() error {
+	encoder := json.NewEncoder(j.w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(j.report)
+}
@@ -0,0 +1,29 @@
+package cfspeed
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ndjsonResultWriter renders every run as a JSON object on its own line as soon as the run completes.
+// Lines are identical to those of the history file, carrying the schema version as each of them is read independently.
+type ndjsonResultWriter struct {
+	encoder *json.Encoder
+}
+
+func newNDJSONResultWriter(w io.Writer) *ndjsonResultWriter {
+	return &ndjsonResultWriter{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (n *ndjsonResultWriter) WriteRun(run *RunResult) error {
+	return n.encoder.Encode(&HistoryRecord{
+		SchemaVersion: ResultSchemaVersion,
+		RunResult:     run,
+	})
+}
+
+func (n *ndjsonResultWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,267 @@
+package cfspeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	otlpMetricsPath              = "/v1/metrics"
+	otlpScopeName                = "github.com/makotom/cfspeed"
+	otlpAggregationTemporalDelta = 1
+)
+
+// Bounds of histogram buckets; Mbps for throughput and ms for RTT
+var (
+	otlpThroughputBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+	otlpRTTBounds        = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000}
+)
+
+// OTLPOptions locates an OTLP/HTTP receiver
+type OTLPOptions struct {
+	Endpoint       string            // Base URL to which /v1/metrics is appended, e.g. http://localhost:4318
+	Header         map[string]string // Additional HTTP headers such as for authentication
+	ServiceVersion string
+}
+
+func (o *OTLPOptions) Validate() error {
+	parsedURL, err := url.Parse(o.Endpoint)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return fmt.Errorf(`invalid OTLP endpoint "%s"; it needs to be an absolute HTTP(S) URL`, o.Endpoint)
+	}
+
+	return nil
+}
+
+// The types below mirror the JSON encoding of ExportMetricsServiceRequest of OTLP, in which 64-bit integers are strings
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []*otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+	Min               float64         `json:"min"`
+	Max               float64         `json:"max"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []*otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                       `json:"aggregationTemporality"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Unit        string         `json:"unit"`
+	Histogram   *otlpHistogram `json:"histogram"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope     `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource        `json:"resource"`
+	ScopeMetrics []*otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+func otlpAttribute(key, value string) *otlpKeyValue {
+	return &otlpKeyValue{
+		Key:   key,
+		Value: otlpAnyValue{StringValue: value},
+	}
+}
+
+func formatOTLPTime(timestamp time.Time) string {
+	return strconv.FormatInt(timestamp.UnixNano(), 10)
+}
+
+// getOTLPHistogramDataPoint buckets samples by bounds, where a bucket covers values greater than the previous bound and up to its own
+func getOTLPHistogramDataPoint(samples []float64, bounds []float64, start, end time.Time, attributes ...*otlpKeyValue) *otlpHistogramDataPoint {
+	bucketCounts := make([]int64, len(bounds)+1)
+	sum := float64(0)
+	minSample := samples[0]
+	maxSample := samples[0]
+
+	for _, sample := range samples {
+		bucket := len(bounds)
+		for index, bound := range bounds {
+			if sample <= bound {
+				bucket = index
+				break
+			}
+		}
+		bucketCounts[bucket] += 1
+
+		sum += sample
+		minSample = min(minSample, sample)
+		maxSample = max(maxSample, sample)
+	}
+
+	formattedBucketCounts := make([]string, len(bucketCounts))
+	for index, count := range bucketCounts {
+		formattedBucketCounts[index] = strconv.FormatInt(count, 10)
+	}
+
+	return &otlpHistogramDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: formatOTLPTime(start),
+		TimeUnixNano:      formatOTLPTime(end),
+		Count:             strconv.Itoa(len(samples)),
+		Sum:               sum,
+		BucketCounts:      formattedBucketCounts,
+		ExplicitBounds:    bounds,
+		Min:               minSample,
+		Max:               maxSample,
+	}
+}
+
+// getOTLPResourceMetrics converts a run into metrics of a resource described by the metadata of the run.
+// Phases without samples, e.g. those restored from history, are left out.
+func getOTLPResourceMetrics(run *RunResult, serviceVersion string, exportedAt time.Time) *otlpResourceMetrics {
+	resourceAttributes := []*otlpKeyValue{
+		otlpAttribute("service.name", "cfspeed"),
+		otlpAttribute("cfspeed.transport_protocol", run.TransportProtocol),
+	}
+	if serviceVersion != "" {
+		resourceAttributes = append(resourceAttributes, otlpAttribute("service.version", serviceVersion))
+	}
+	if run.Metadata != nil {
+		resourceAttributes = append(resourceAttributes,
+			otlpAttribute("cfspeed.src_ip", run.Metadata.SrcIP),
+			otlpAttribute("cfspeed.src_asn", run.Metadata.SrcASN),
+			otlpAttribute("cfspeed.src_city", run.Metadata.SrcCity),
+			otlpAttribute("cfspeed.src_country", run.Metadata.SrcCountry),
+			otlpAttribute("cfspeed.dst_colo", run.Metadata.DstColo),
+		)
+	}
+
+	throughputDataPoints := []*otlpHistogramDataPoint{}
+	for _, speed := range []struct {
+		stats     *SpeedMeasurementStats
+		direction string
+	}{
+		{run.Downlink, DirectionDownlink},
+		{run.Uplink, DirectionUplink},
+		{run.BidirectionalDownlink, DirectionBidirectionalDownlink},
+		{run.BidirectionalUplink, DirectionBidirectionalUplink},
+	} {
+		if speed.stats != nil && len(speed.stats.Samples) > 0 {
+			throughputDataPoints = append(throughputDataPoints, getOTLPHistogramDataPoint(speed.stats.Samples, otlpThroughputBounds, run.Timestamp, exportedAt, otlpAttribute("direction", speed.direction)))
+		}
+	}
+
+	rttDataPoints := []*otlpHistogramDataPoint{}
+	for _, rtt := range []struct {
+		stats *Stats
+		load  string
+	}{
+		{run.UnloadedRTT, "unloaded"},
+		{run.DownlinkLoadedRTT, "downlink"},
+		{run.UplinkLoadedRTT, "uplink"},
+		{run.BidirectionalLoadedRTT, "bidirectional"},
+	} {
+		if rtt.stats != nil && len(rtt.stats.Samples) > 0 {
+			rttDataPoints = append(rttDataPoints, getOTLPHistogramDataPoint(rtt.stats.Samples, otlpRTTBounds, run.Timestamp, exportedAt, otlpAttribute("load", rtt.load)))
+		}
+	}
+
+	metrics := []*otlpMetric{}
+	if len(throughputDataPoints) > 0 {
+		metrics = append(metrics, &otlpMetric{
+			Name:        "cfspeed.throughput",
+			Description: "Throughput sampled per I/O window",
+			Unit:        "Mbit/s",
+			Histogram: &otlpHistogram{
+				DataPoints:             throughputDataPoints,
+				AggregationTemporality: otlpAggregationTemporalDelta,
+			},
+		})
+	}
+	if len(rttDataPoints) > 0 {
+		metrics = append(metrics, &otlpMetric{
+			Name:        "cfspeed.rtt",
+			Description: "Round-trip time, unloaded or under load of either direction",
+			Unit:        "ms",
+			Histogram: &otlpHistogram{
+				DataPoints:             rttDataPoints,
+				AggregationTemporality: otlpAggregationTemporalDelta,
+			},
+		})
+	}
+
+	return &otlpResourceMetrics{
+		Resource: otlpResource{Attributes: resourceAttributes},
+		ScopeMetrics: []*otlpScopeMetrics{
+			{
+				Scope:   otlpScope{Name: otlpScopeName, Version: serviceVersion},
+				Metrics: metrics,
+			},
+		},
+	}
+}
+
+func getOTLPSendFunc(opts *OTLPOptions, httpClient *http.Client) sinkSendFunc {
+	metricsURL := strings.TrimSuffix(opts.Endpoint, "/") + otlpMetricsPath
+	header := http.Header{}
+	for key, value := range opts.Header {
+		header.Set(key, value)
+	}
+	header.Set("Content-Type", "application/json")
+
+	// lines are resourceMetrics encoded in JSON, put together into a single request
+	return func(lines []string, timeout time.Duration) error {
+		payload := `{"resourceMetrics":[` + strings.Join(lines, ",") + `]}`
+		return postSinkPayload(httpClient, metricsURL, header, []byte(payload), timeout)
+	}
+}
+
+// NewOTLPResultWriter exports runs to an OTLP/HTTP receiver in JSON.
+// Throughput and RTT are recorded as histograms of the raw samples rather than their summaries.
+func NewOTLPResultWriter(ctx context.Context, opts *OTLPOptions, sinkOpts *SinkOptions) (ResultWriter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := sinkOpts.Validate(); err != nil {
+		return nil, err
+	}
+
+	encode := func(run *RunResult) []string {
+		encoded, err := json.Marshal(getOTLPResourceMetrics(run, opts.ServiceVersion, time.Now()))
+		if err != nil {
+			return []string{}
+		}
+
+		return []string{string(encoded)}
+	}
+
+	return newSinkResultWriter(ctx, "OTLP receiver", encode, getOTLPSendFunc(opts, &http.Client{}), sinkOpts), nil
+}
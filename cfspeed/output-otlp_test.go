@@ -0,0 +1,134 @@
+package cfspeed
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+type otlpTestRequest struct {
+	ResourceMetrics []*otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func startDummyOTLPCollector(t *testing.T) (string, func() []*otlpTestRequest) {
+	mutex := &sync.Mutex{}
+	requests := []*otlpTestRequest{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != otlpMetricsPath || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		request := &otlpTestRequest{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mutex.Lock()
+		requests = append(requests, request)
+		mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, func() []*otlpTestRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return requests
+	}
+}
+
+func getOTLPTestAttribute(attributes []*otlpKeyValue, key string) string {
+	for _, attribute := range attributes {
+		if attribute.Key == key {
+			return attribute.Value.StringValue
+		}
+	}
+
+	return ""
+}
+
+func TestOTLPResultWriter(t *testing.T) {
+	endpoint, getRequests := startDummyOTLPCollector(t)
+	client := startDummyServer(t)
+
+	rttStats, _, err := client.MeasureRTT(context.Background())
+	assert.NilError(t, err)
+
+	run := generateDummyRunResult()
+	run.UnloadedRTT = rttStats
+	run.Downlink = AnalyseSpeedMeasurements(generateDummyGroupedMeasurements(), true)
+
+	resultWriter, err := NewOTLPResultWriter(context.Background(), &OTLPOptions{Endpoint: endpoint + "/", Header: map[string]string{"X-Api-Key": "secret"}, ServiceVersion: "v0.0.0"}, getTestSinkOptions())
+	assert.NilError(t, err)
+
+	assert.NilError(t, resultWriter.WriteRun(run))
+	// runs without samples, e.g. those from history, yield no metrics
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.Close())
+
+	requests := getRequests()
+	assert.Equal(t, len(requests), 2)
+	assert.Equal(t, len(requests[0].ResourceMetrics), 1)
+
+	resourceMetrics := requests[0].ResourceMetrics[0]
+	assert.Equal(t, getOTLPTestAttribute(resourceMetrics.Resource.Attributes, "service.version"), "v0.0.0")
+	assert.Equal(t, getOTLPTestAttribute(resourceMetrics.Resource.Attributes, "cfspeed.transport_protocol"), "tcp4")
+	assert.Equal(t, getOTLPTestAttribute(resourceMetrics.Resource.Attributes, "cfspeed.dst_colo"), "NRT")
+	assert.Equal(t, getOTLPTestAttribute(resourceMetrics.Resource.Attributes, "cfspeed.src_asn"), "64496")
+
+	metrics := resourceMetrics.ScopeMetrics[0].Metrics
+	assert.Equal(t, len(metrics), 2)
+
+	for _, expected := range []struct {
+		metric   *otlpMetric
+		name     string
+		nSamples int
+		max      float64
+	}{
+		{metrics[0], "cfspeed.throughput", run.Downlink.NSamples, run.Downlink.Max},
+		{metrics[1], "cfspeed.rtt", run.UnloadedRTT.NSamples, run.UnloadedRTT.Max},
+	} {
+		assert.Equal(t, expected.metric.Name, expected.name)
+		assert.Equal(t, len(expected.metric.Histogram.DataPoints), 1)
+
+		dataPoint := expected.metric.Histogram.DataPoints[0]
+		assert.Equal(t, dataPoint.Count, strconv.Itoa(expected.nSamples))
+		assert.Equal(t, dataPoint.Max, expected.max)
+		assert.Equal(t, len(dataPoint.BucketCounts), len(dataPoint.ExplicitBounds)+1)
+
+		nCounted := 0
+		for _, bucketCount := range dataPoint.BucketCounts {
+			count, err := strconv.Atoi(bucketCount)
+			assert.NilError(t, err)
+			nCounted += count
+		}
+		assert.Equal(t, nCounted, expected.nSamples)
+	}
+
+	assert.Equal(t, getOTLPTestAttribute(metrics[0].Histogram.DataPoints[0].Attributes, "direction"), DirectionDownlink)
+	assert.Equal(t, getOTLPTestAttribute(metrics[1].Histogram.DataPoints[0].Attributes, "load"), "unloaded")
+	assert.Equal(t, len(requests[1].ResourceMetrics[0].ScopeMetrics[0].Metrics), 0)
+}
+
+func TestGetOTLPHistogramDataPoint(t *testing.T) {
+	dataPoint := getOTLPHistogramDataPoint([]float64{0.5, 1, 1.5, 3, 30}, []float64{1, 2, 5}, generateDummyRunResult().Timestamp, generateDummyRunResult().Timestamp)
+
+	assert.DeepEqual(t, dataPoint.BucketCounts, []string{"2", "1", "1", "1"})
+	assert.Equal(t, dataPoint.Count, "5")
+	assert.Equal(t, dataPoint.Sum, 36.0)
+	assert.Equal(t, dataPoint.Min, 0.5)
+	assert.Equal(t, dataPoint.Max, 30.0)
+	assert.Equal(t, dataPoint.StartTimeUnixNano, "1711972800000000000")
+}
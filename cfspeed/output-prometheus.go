@@ -0,0 +1,236 @@
+package cfspeed
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type promSample struct {
+	labels [][2]string
+	value  float64
+}
+
+type promMetric struct {
+	name    string
+	help    string
+	samples []*promSample
+}
+
+// promResultWriter renders runs in the Prometheus text exposition format.
+// Samples are buffered until Close since every metric family has to be rendered contiguously.
+type promResultWriter struct {
+	w       io.Writer
+	metrics []*promMetric
+	byName  map[string]*promMetric
+}
+
+func newPromResultWriter(w io.Writer) *promResultWriter {
+	return &promResultWriter{
+		w:       w,
+		metrics: []*promMetric{},
+		byName:  map[string]*promMetric{},
+	}
+}
+
+func (p *promResultWriter) add(name, help string, value float64, labels ...[2]string) {
+	metric, ok := p.byName[name]
+	if !ok {
+		metric = &promMetric{
+			name: name,
+			help: help,
+		}
+		p.metrics = append(p.metrics, metric)
+		p.byName[name] = metric
+	}
+
+	metric.samples = append(metric.samples, &promSample{
+		labels: labels,
+		value:  value,
+	})
+}
+
+func promLabel(key, value string) [2]string {
+	return [2]string{key, value}
+}
+
+// addConnPhases adds phases of requests under the prefix of the metrics of the measurement, e.g. cfspeed_rtt
+func (p *promResultWriter) addConnPhases(prefix string, phases *ConnPhaseStats, labels ...[2]string) {
+	if phases == nil {
+		return
+	}
+
+	for _, phase := range phases.getPhases() {
+		p.add(prefix+"_phase_mean_seconds", "Mean duration of phases of requests; dns, connect and tls are of new connections only", phase.stats.Mean/1000, append(labels, promLabel("phase", phase.name))...)
+	}
+	p.add(prefix+"_requests", "Number of requests made", float64(phases.NRequests), labels...)
+	p.add(prefix+"_requests_reused", "Number of requests made over reused connections", float64(phases.NReused), labels...)
+}
+
+func (p *promResultWriter) addRTT(stats *Stats, protocolLabel [2]string, load string) {
+	if stats == nil {
+		return
+	}
+
+	loadLabel := promLabel("load", load)
+
+	p.add("cfspeed_rtt_mean_seconds", "Mean of RTT", stats.Mean/1000, protocolLabel, loadLabel)
+	p.add("cfspeed_rtt_stderr_seconds", "Standard error of RTT", stats.StdErr/1000, protocolLabel, loadLabel)
+	p.add("cfspeed_rtt_min_seconds", "Minimum of RTT", stats.Min/1000, protocolLabel, loadLabel)
+	p.add("cfspeed_rtt_max_seconds", "Maximum of RTT", stats.Max/1000, protocolLabel, loadLabel)
+	for index, decile := range stats.Deciles {
+		p.add("cfspeed_rtt_seconds", "Deciles of RTT", decile/1000, protocolLabel, loadLabel, promLabel("quantile", fmt.Sprintf("%.1f", float64(index+1)/10)))
+	}
+	p.add("cfspeed_rtt_jitter_seconds", "Mean of absolute differences between consecutive RTT samples", stats.Jitter/1000, protocolLabel, loadLabel)
+	p.add("cfspeed_rtt_samples", "Number of RTT samples", float64(stats.NSamples), protocolLabel, loadLabel)
+	p.addConnPhases("cfspeed_rtt", stats.Phases, protocolLabel, loadLabel)
+}
+
+func (p *promResultWriter) addSpeed(stats *SpeedMeasurementStats, protocolLabel [2]string, direction string) {
+	if stats == nil {
+		return
+	}
+
+	directionLabel := promLabel("direction", direction)
+
+	// Mbps to bps
+	p.add("cfspeed_speed_mean_bits_per_second", "Mean of speed", stats.Mean*1e6, protocolLabel, directionLabel)
+	p.add("cfspeed_speed_stderr_bits_per_second", "Standard error of speed", stats.StdErr*1e6, protocolLabel, directionLabel)
+	p.add("cfspeed_speed_min_bits_per_second", "Minimum of speed", stats.Min*1e6, protocolLabel, directionLabel)
+	p.add("cfspeed_speed_max_bits_per_second", "Maximum of speed", stats.Max*1e6, protocolLabel, directionLabel)
+	for index, decile := range stats.Deciles {
+		p.add("cfspeed_speed_bits_per_second", "Deciles of speed", decile*1e6, protocolLabel, directionLabel, promLabel("quantile", fmt.Sprintf("%.1f", float64(index+1)/10)))
+	}
+	p.add("cfspeed_speed_cat_bits_per_second", "Speed derived from the total size and duration of transfers", stats.CatSpeed*1e6, protocolLabel, directionLabel)
+	p.add("cfspeed_speed_tx_bytes", "Total size of transfers", float64(stats.TXSize), protocolLabel, directionLabel)
+	p.add("cfspeed_speed_multiplicity", "Number of connections in parallel", float64(stats.Multiplicity), protocolLabel, directionLabel)
+	p.add("cfspeed_speed_samples", "Number of speed samples", float64(stats.NSamples), protocolLabel, directionLabel)
+	p.addConnPhases("cfspeed_speed", stats.Phases, protocolLabel, directionLabel)
+
+	truncated := float64(0)
+	if stats.Truncated {
+		truncated = 1
+	}
+	p.add("cfspeed_speed_truncated", "Whether the measurement was cut short by the data budget", truncated, protocolLabel, directionLabel)
+}
+
+func (p *promResultWriter) addResponsiveness(stats *ResponsivenessStats, protocolLabel [2]string) {
+	if stats == nil {
+		return
+	}
+
+	p.add("cfspeed_responsiveness_rpm", "Round-trips per minute under working conditions", stats.RPM, protocolLabel)
+	p.add("cfspeed_responsiveness_confidence", "Confidence in RPM; 0 for low, 1 for medium and 2 for high", float64(getResponsivenessConfidenceLevel(stats.Confidence)), protocolLabel)
+	p.add("cfspeed_responsiveness_connections", "Load-generating connections per direction", float64(stats.Connections), protocolLabel)
+	p.add("cfspeed_responsiveness_throughput_bits_per_second", "Throughput under which responsiveness was measured", stats.DownlinkMBPS*1e6, protocolLabel, promLabel("direction", DirectionDownlink))
+	p.add("cfspeed_responsiveness_throughput_bits_per_second", "Throughput under which responsiveness was measured", stats.UplinkMBPS*1e6, protocolLabel, promLabel("direction", DirectionUplink))
+	for _, component := range []struct {
+		stats *Stats
+		name  string
+	}{
+		{stats.ForeignTCP, "foreign_tcp"},
+		{stats.ForeignTLS, "foreign_tls"},
+		{stats.ForeignHTTP, "foreign_http"},
+		{stats.SelfHTTP, "self_http"},
+	} {
+		if component.stats != nil {
+			p.add("cfspeed_responsiveness_latency_mean_seconds", "Mean of latency components of responsiveness probes", component.stats.Mean/1000, protocolLabel, promLabel("component", component.name))
+		}
+	}
+}
+
+func (p *promResultWriter) addScores(scores *Scores, protocolLabel [2]string) {
+	if scores == nil {
+		return
+	}
+
+	if scores.Bufferbloat != "" {
+		p.add("cfspeed_bufferbloat_grade", "Bufferbloat grade; 5 for A+, 4 for A down to 0 for F", float64(getBufferbloatGradeLevel(scores.Bufferbloat)), protocolLabel)
+		p.add("cfspeed_latency_increase_seconds", "Increase of mean RTT under the heaviest of the loads measured", scores.LatencyIncrease/1000, protocolLabel)
+	}
+	for _, useCase := range scores.getSortedUseCases() {
+		p.add("cfspeed_use_case_rating", "Rating of the connection for the use case; 4 for great, 3 for good, 2 for average, 1 for poor and 0 for bad", float64(getRatingLevel(scores.UseCases[useCase])), protocolLabel, promLabel("use_case", useCase))
+	}
+}
+
+func (p *promResultWriter) WriteRun(run *RunResult) error {
+	protocolLabel := promLabel("transport_protocol", run.TransportProtocol)
+
+	success := float64(0)
+	if run.Error == "" {
+		success = 1
+	}
+	p.add("cfspeed_run_success", "Whether the run completed without errors", success, protocolLabel)
+	p.add("cfspeed_run_timestamp_seconds", "Time at which the run started", float64(run.Timestamp.UnixMilli())/1000, protocolLabel)
+
+	if run.Metadata != nil {
+		p.add("cfspeed_metadata_info", "Metadata reported by the server", 1,
+			protocolLabel,
+			promLabel("src_ip", run.Metadata.SrcIP),
+			promLabel("src_asn", run.Metadata.SrcASN),
+			promLabel("src_city", run.Metadata.SrcCity),
+			promLabel("src_country", run.Metadata.SrcCountry),
+			promLabel("dst_colo", run.Metadata.DstColo),
+		)
+		p.addConnPhases("cfspeed_metadata", run.Metadata.Phases, protocolLabel)
+	}
+
+	p.addRTT(run.UnloadedRTT, protocolLabel, "unloaded")
+	p.addSpeed(run.Downlink, protocolLabel, DirectionDownlink)
+	p.addRTT(run.DownlinkLoadedRTT, protocolLabel, "downlink")
+	p.addSpeed(run.Uplink, protocolLabel, DirectionUplink)
+	p.addRTT(run.UplinkLoadedRTT, protocolLabel, "uplink")
+	p.addSpeed(run.BidirectionalDownlink, protocolLabel, DirectionBidirectionalDownlink)
+	p.addSpeed(run.BidirectionalUplink, protocolLabel, DirectionBidirectionalUplink)
+	p.addRTT(run.BidirectionalLoadedRTT, protocolLabel, "bidirectional")
+	p.addResponsiveness(run.Responsiveness, protocolLabel)
+	p.addScores(run.Scores, protocolLabel)
+
+	for _, check := range run.Checks {
+		// checks not evaluated neither pass nor fail
+		if !check.Measured {
+			continue
+		}
+
+		passed := float64(0)
+		if check.Passed {
+			passed = 1
+		}
+		p.add("cfspeed_check_passed", "Whether the threshold check passed", passed, protocolLabel, promLabel("check", check.Name))
+	}
+
+	return nil
+}
+
+var promLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatPromSample(name string, sample *promSample) string {
+	labelStrs := make([]string, len(sample.labels))
+	for index, label := range sample.labels {
+		labelStrs[index] = fmt.Sprintf(`%s="%s"`, label[0], promLabelValueEscaper.Replace(label[1]))
+	}
+
+	return fmt.Sprintf("%s{%s} %s\n", name, strings.Join(labelStrs, ","), strconv.FormatFloat(sample.value, 'g', -1, 64))
+}
+
+func (p *promResultWriter) Close() error {
+	sort.SliceStable(p.metrics, func(i, j int) bool {
+		return p.metrics[i].name < p.metrics[j].name
+	})
+
+	for _, metric := range p.metrics {
+		if _, err := fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name); err != nil {
+			return err
+		}
+
+		for _, sample := range metric.samples {
+			if _, err := io.WriteString(p.w, formatPromSample(metric.name, sample)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
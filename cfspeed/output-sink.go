@@ -0,0 +1,408 @@
+package cfspeed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSinkBatchSize     = 1
+	defaultSinkMaxRetries    = 3
+	defaultSinkRetryInterval = 1 * time.Second
+	defaultSinkTimeout       = 10 * time.Second
+	defaultSinkMaxPending    = 10000
+)
+
+// SinkOptions configures how results are pushed to a remote sink
+type SinkOptions struct {
+	BatchSize     int           // Number of runs sent together
+	MaxRetries    int           // Number of retries of a failed send
+	RetryInterval time.Duration // Delay before the first retry, doubled on every retry
+	Timeout       time.Duration // Timeout of each attempt to send
+	MaxPending    int           // Maximum number of lines retained while the sink is down; the oldest are discarded beyond this
+}
+
+func NewSinkOptions() *SinkOptions {
+	return &SinkOptions{
+		BatchSize:     defaultSinkBatchSize,
+		MaxRetries:    defaultSinkMaxRetries,
+		RetryInterval: defaultSinkRetryInterval,
+		Timeout:       defaultSinkTimeout,
+		MaxPending:    defaultSinkMaxPending,
+	}
+}
+
+func (o *SinkOptions) Validate() error {
+	if o.BatchSize < 1 {
+		return fmt.Errorf(`invalid batch size "%d"; it needs to be a positive integer`, o.BatchSize)
+	}
+	if o.MaxRetries < 0 {
+		return fmt.Errorf(`invalid number of retries "%d"; it needs to be a non-negative integer`, o.MaxRetries)
+	}
+	if o.RetryInterval < 0 {
+		return fmt.Errorf(`invalid retry interval "%s"; it needs to be non-negative`, o.RetryInterval)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf(`invalid timeout "%s"; it needs to be positive`, o.Timeout)
+	}
+	if o.MaxPending < 1 {
+		return fmt.Errorf(`invalid number of pending lines "%d"; it needs to be a positive integer`, o.MaxPending)
+	}
+
+	return nil
+}
+
+// sinkField is a named value of a sinkPoint; integers are distinguished as some sinks type them differently
+type sinkField struct {
+	key     string
+	value   float64
+	integer bool
+}
+
+// sinkPoint is a set of values sharing a name, tags and a timestamp, which is what both InfluxDB and Graphite deal with
+type sinkPoint struct {
+	name      string
+	tags      [][2]string
+	fields    []*sinkField
+	timestamp time.Time
+}
+
+func getDecileSinkFields(deciles []float64) []*sinkField {
+	fields := []*sinkField{}
+	for index, decile := range deciles {
+		fields = append(fields, &sinkField{key: fmt.Sprintf("d%d", index+1), value: decile})
+	}
+
+	return fields
+}
+
+func getConnPhaseSinkFields(phases *ConnPhaseStats) []*sinkField {
+	if phases == nil {
+		return []*sinkField{}
+	}
+
+	fields := []*sinkField{}
+	for _, phase := range phases.getPhases() {
+		fields = append(fields, &sinkField{key: phase.name, value: phase.stats.Mean})
+	}
+
+	return append(fields,
+		&sinkField{key: "requests", value: float64(phases.NRequests), integer: true},
+		&sinkField{key: "requests_reused", value: float64(phases.NReused), integer: true},
+	)
+}
+
+func getRTTSinkPoint(stats *Stats, tags [][2]string, load string, timestamp time.Time) *sinkPoint {
+	fields := []*sinkField{
+		{key: "mean", value: stats.Mean},
+		{key: "stderr", value: stats.StdErr},
+		{key: "min", value: stats.Min},
+		{key: "max", value: stats.Max},
+	}
+	fields = append(fields, getDecileSinkFields(stats.Deciles)...)
+	fields = append(fields,
+		&sinkField{key: "jitter", value: stats.Jitter},
+		&sinkField{key: "samples", value: float64(stats.NSamples), integer: true},
+	)
+	fields = append(fields, getConnPhaseSinkFields(stats.Phases)...)
+
+	return &sinkPoint{
+		name:      "rtt",
+		tags:      append(append([][2]string{}, tags...), [2]string{"load", load}),
+		fields:    fields,
+		timestamp: timestamp,
+	}
+}
+
+func getSpeedSinkPoint(stats *SpeedMeasurementStats, tags [][2]string, direction string, timestamp time.Time) *sinkPoint {
+	fields := []*sinkField{
+		{key: "mean", value: stats.Mean},
+		{key: "stderr", value: stats.StdErr},
+		{key: "min", value: stats.Min},
+		{key: "max", value: stats.Max},
+	}
+	fields = append(fields, getDecileSinkFields(stats.Deciles)...)
+	fields = append(fields,
+		&sinkField{key: "cat", value: stats.CatSpeed},
+		&sinkField{key: "tx_bytes", value: float64(stats.TXSize), integer: true},
+		&sinkField{key: "multiplicity", value: float64(stats.Multiplicity), integer: true},
+		&sinkField{key: "samples", value: float64(stats.NSamples), integer: true},
+	)
+	fields = append(fields, getConnPhaseSinkFields(stats.Phases)...)
+	if stats.Truncated {
+		fields = append(fields, &sinkField{key: "truncated", value: 1, integer: true})
+	}
+
+	return &sinkPoint{
+		name:      "speed",
+		tags:      append(append([][2]string{}, tags...), [2]string{"direction", direction}),
+		fields:    fields,
+		timestamp: timestamp,
+	}
+}
+
+func getResponsivenessSinkPoint(stats *ResponsivenessStats, tags [][2]string, timestamp time.Time) *sinkPoint {
+	fields := []*sinkField{
+		{key: "rpm", value: stats.RPM},
+		{key: "confidence", value: float64(getResponsivenessConfidenceLevel(stats.Confidence)), integer: true},
+		{key: "connections", value: float64(stats.Connections), integer: true},
+		{key: "downlink", value: stats.DownlinkMBPS},
+		{key: "uplink", value: stats.UplinkMBPS},
+	}
+	for _, component := range []struct {
+		stats *Stats
+		key   string
+	}{
+		{stats.ForeignTCP, "foreign_tcp"},
+		{stats.ForeignTLS, "foreign_tls"},
+		{stats.ForeignHTTP, "foreign_http"},
+		{stats.SelfHTTP, "self_http"},
+	} {
+		if component.stats != nil {
+			fields = append(fields, &sinkField{key: component.key, value: component.stats.Mean})
+		}
+	}
+	if stats.Truncated {
+		fields = append(fields, &sinkField{key: "truncated", value: 1, integer: true})
+	}
+
+	return &sinkPoint{
+		name:      "responsiveness",
+		tags:      tags,
+		fields:    fields,
+		timestamp: timestamp,
+	}
+}
+
+// getScoresSinkPoint gives grades and ratings by their levels, as sinks deal with numbers only
+func getScoresSinkPoint(scores *Scores, tags [][2]string, timestamp time.Time) *sinkPoint {
+	fields := []*sinkField{}
+	if scores.Bufferbloat != "" {
+		fields = append(fields,
+			&sinkField{key: "bufferbloat", value: float64(getBufferbloatGradeLevel(scores.Bufferbloat)), integer: true},
+			&sinkField{key: "latency_increase", value: scores.LatencyIncrease},
+		)
+	}
+	for _, useCase := range scores.getSortedUseCases() {
+		fields = append(fields, &sinkField{key: strings.ReplaceAll(useCase, "-", "_"), value: float64(getRatingLevel(scores.UseCases[useCase])), integer: true})
+	}
+
+	return &sinkPoint{
+		name:      "scores",
+		tags:      tags,
+		fields:    fields,
+		timestamp: timestamp,
+	}
+}
+
+// getSinkPoints flattens a run into points; RTT is in ms and speed in Mbps as elsewhere
+func getSinkPoints(run *RunResult) []*sinkPoint {
+	tags := [][2]string{{"protocol", run.TransportProtocol}}
+	if run.Metadata != nil {
+		tags = append(tags,
+			[2]string{"colo", run.Metadata.DstColo},
+			[2]string{"asn", run.Metadata.SrcASN},
+			[2]string{"country", run.Metadata.SrcCountry},
+		)
+	}
+
+	success := float64(1)
+	if run.Error != "" {
+		success = 0
+	}
+
+	points := []*sinkPoint{
+		{
+			name:      "run",
+			tags:      tags,
+			fields:    []*sinkField{{key: "success", value: success, integer: true}},
+			timestamp: run.Timestamp,
+		},
+	}
+
+	if run.Metadata != nil && run.Metadata.Phases != nil {
+		points = append(points, &sinkPoint{
+			name:      "metadata",
+			tags:      tags,
+			fields:    getConnPhaseSinkFields(run.Metadata.Phases),
+			timestamp: run.Timestamp,
+		})
+	}
+	if run.UnloadedRTT != nil {
+		points = append(points, getRTTSinkPoint(run.UnloadedRTT, tags, "unloaded", run.Timestamp))
+	}
+	if run.Downlink != nil {
+		points = append(points, getSpeedSinkPoint(run.Downlink, tags, DirectionDownlink, run.Timestamp))
+	}
+	if run.DownlinkLoadedRTT != nil {
+		points = append(points, getRTTSinkPoint(run.DownlinkLoadedRTT, tags, "downlink", run.Timestamp))
+	}
+	if run.Uplink != nil {
+		points = append(points, getSpeedSinkPoint(run.Uplink, tags, DirectionUplink, run.Timestamp))
+	}
+	if run.UplinkLoadedRTT != nil {
+		points = append(points, getRTTSinkPoint(run.UplinkLoadedRTT, tags, "uplink", run.Timestamp))
+	}
+	if run.BidirectionalDownlink != nil {
+		points = append(points, getSpeedSinkPoint(run.BidirectionalDownlink, tags, DirectionBidirectionalDownlink, run.Timestamp))
+	}
+	if run.BidirectionalUplink != nil {
+		points = append(points, getSpeedSinkPoint(run.BidirectionalUplink, tags, DirectionBidirectionalUplink, run.Timestamp))
+	}
+	if run.BidirectionalLoadedRTT != nil {
+		points = append(points, getRTTSinkPoint(run.BidirectionalLoadedRTT, tags, "bidirectional", run.Timestamp))
+	}
+	if run.Responsiveness != nil {
+		points = append(points, getResponsivenessSinkPoint(run.Responsiveness, tags, run.Timestamp))
+	}
+	if run.Scores != nil && (run.Scores.Bufferbloat != "" || len(run.Scores.UseCases) > 0) {
+		points = append(points, getScoresSinkPoint(run.Scores, tags, run.Timestamp))
+	}
+
+	return points
+}
+
+// errSinkPermanent marks failures which retrying cannot resolve, e.g. rejection of the payload
+var errSinkPermanent = errors.New("permanent sink failure")
+
+// postSinkPayload sends a payload to an HTTP sink, telling failures worth retrying from those which are not
+func postSinkPayload(httpClient *http.Client, url string, header http.Header, payload []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	// rate limiting and server-side failures may well go away
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	default:
+		return fmt.Errorf("%w; unexpected status %s: %s", errSinkPermanent, resp.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+// sendWithRetries retries send with exponential backoff until it succeeds or fails permanently.
+// Cancellation of ctx ends the backoff, yielding the error of the last attempt.
+func sendWithRetries(ctx context.Context, maxRetries int, retryInterval time.Duration, send func() error) error {
+	var err error = nil
+
+	for attempt := 0; attempt <= maxRetries; attempt += 1 {
+		if attempt > 0 {
+			timer := time.NewTimer(retryInterval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+			retryInterval *= 2
+		}
+
+		err = send()
+		if err == nil || errors.Is(err, errSinkPermanent) {
+			break
+		}
+	}
+
+	return err
+}
+
+type sinkEncodeFunc func(run *RunResult) []string
+type sinkSendFunc func(lines []string, timeout time.Duration) error
+
+// getSinkPointsEncodeFunc encodes runs by the points of them
+func getSinkPointsEncodeFunc(encodePoint func(point *sinkPoint) []string) sinkEncodeFunc {
+	return func(run *RunResult) []string {
+		lines := []string{}
+		for _, point := range getSinkPoints(run) {
+			lines = append(lines, encodePoint(point)...)
+		}
+
+		return lines
+	}
+}
+
+// sinkResultWriter batches lines encoded from runs and sends them with retries.
+// Lines which could not be sent are retained, up to MaxPending, and sent along with the next batch.
+type sinkResultWriter struct {
+	ctx         context.Context // Bounds waiting between retries
+	name        string
+	encode      sinkEncodeFunc
+	send        sinkSendFunc
+	opts        SinkOptions
+	pending     []string
+	pendingRuns int
+}
+
+func newSinkResultWriter(ctx context.Context, name string, encode sinkEncodeFunc, send sinkSendFunc, opts *SinkOptions) *sinkResultWriter {
+	return &sinkResultWriter{
+		ctx:     ctx,
+		name:    name,
+		encode:  encode,
+		send:    send,
+		opts:    *opts,
+		pending: []string{},
+	}
+}
+
+func (s *sinkResultWriter) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	err := sendWithRetries(s.ctx, s.opts.MaxRetries, s.opts.RetryInterval, func() error {
+		return s.send(s.pending, s.opts.Timeout)
+	})
+	if err != nil && !errors.Is(err, errSinkPermanent) {
+		return fmt.Errorf("could not send to %s; %d lines retained: %w", s.name, len(s.pending), err)
+	}
+
+	// lines rejected by the sink are dropped as they would never be accepted
+	s.pending = []string{}
+	s.pendingRuns = 0
+
+	if err != nil {
+		return fmt.Errorf("%s rejected lines: %w", s.name, err)
+	}
+
+	return nil
+}
+
+func (s *sinkResultWriter) WriteRun(run *RunResult) error {
+	s.pending = append(s.pending, s.encode(run)...)
+	if len(s.pending) > s.opts.MaxPending {
+		s.pending = s.pending[len(s.pending)-s.opts.MaxPending:]
+	}
+
+	s.pendingRuns += 1
+	if s.pendingRuns < s.opts.BatchSize {
+		return nil
+	}
+
+	return s.flush()
+}
+
+func (s *sinkResultWriter) Close() error {
+	return s.flush()
+}
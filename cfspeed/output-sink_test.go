@@ -0,0 +1,226 @@
+package cfspeed
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func getTestSinkOptions() *SinkOptions {
+	sinkOpts := NewSinkOptions()
+	sinkOpts.RetryInterval = 0
+	sinkOpts.Timeout = 5 * time.Second
+
+	return sinkOpts
+}
+
+func TestEncodeInfluxLines(t *testing.T) {
+	lines := encodeInfluxLines(&sinkPoint{
+		name:      "rtt",
+		tags:      [][2]string{{"protocol", "tcp4"}, {"colo", "NRT"}, {"asn", ""}, {"country", "J P,="}},
+		fields:    []*sinkField{{key: "mean", value: 12.5}, {key: "samples", value: 20, integer: true}},
+		timestamp: time.Unix(1700000000, 1),
+	})
+
+	assert.DeepEqual(t, lines, []string{`cfspeed_rtt,protocol=tcp4,colo=NRT,country=J\ P\,\= mean=12.5,samples=20i 1700000000000000001`})
+}
+
+func TestEncodeGraphiteLines(t *testing.T) {
+	lines := encodeGraphiteLines("lab.cfspeed", &sinkPoint{
+		name:      "speed",
+		tags:      [][2]string{{"protocol", "tcp6"}, {"colo", "A;B"}, {"country", ""}},
+		fields:    []*sinkField{{key: "mean", value: 100}, {key: "d1", value: 90.5}},
+		timestamp: time.Unix(1700000000, 1),
+	})
+
+	assert.DeepEqual(t, lines, []string{
+		"lab.cfspeed.speed.mean;protocol=tcp6;colo=A_B 100 1700000000",
+		"lab.cfspeed.speed.d1;protocol=tcp6;colo=A_B 90.5 1700000000",
+	})
+}
+
+func TestInfluxResultWriter_Retry(t *testing.T) {
+	mutex := &sync.Mutex{}
+	nRequests := 0
+	received := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		nRequests += 1
+		if nRequests <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		assert.Check(t, r.URL.Path == "/api/v2/write")
+		assert.Check(t, r.URL.Query().Get("bucket") == "speed")
+		assert.Check(t, r.URL.Query().Get("org") == "lab")
+		assert.Check(t, r.Header.Get("Authorization") == "Token secret")
+
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	resultWriter, err := NewInfluxResultWriter(context.Background(), &InfluxOptions{URL: server.URL, Org: "lab", Bucket: "speed", Token: "secret"}, getTestSinkOptions())
+	assert.NilError(t, err)
+
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.Close())
+
+	assert.Equal(t, nRequests, 3)
+	assert.Equal(t, len(received), 3)
+	assert.Assert(t, strings.HasPrefix(received[0], "cfspeed_run,protocol=tcp4,colo=NRT,asn=64496,country=JP success=1i "))
+	assert.Assert(t, strings.HasPrefix(received[1], "cfspeed_rtt,protocol=tcp4,colo=NRT,asn=64496,country=JP,load=unloaded mean=12,"))
+	assert.Assert(t, strings.HasPrefix(received[2], "cfspeed_speed,protocol=tcp4,colo=NRT,asn=64496,country=JP,direction=down mean=100,"))
+}
+
+func TestInfluxResultWriter_Rejected(t *testing.T) {
+	nRequests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nRequests += 1
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	resultWriter, err := NewInfluxResultWriter(context.Background(), &InfluxOptions{URL: server.URL, Bucket: "speed"}, getTestSinkOptions())
+	assert.NilError(t, err)
+
+	assert.ErrorContains(t, resultWriter.WriteRun(generateDummyRunResult()), "InfluxDB rejected lines")
+	assert.Equal(t, nRequests, 1)
+
+	// rejected lines are not sent again
+	assert.NilError(t, resultWriter.Close())
+	assert.Equal(t, nRequests, 1)
+}
+
+func TestInfluxOptions_Validate(t *testing.T) {
+	assert.ErrorContains(t, (&InfluxOptions{URL: "localhost:8086", Bucket: "speed"}).Validate(), `invalid InfluxDB URL "localhost:8086"`)
+	assert.ErrorContains(t, (&InfluxOptions{URL: "http://localhost:8086"}).Validate(), "bucket")
+	assert.NilError(t, (&InfluxOptions{URL: "http://localhost:8086", Bucket: "speed"}).Validate())
+}
+
+func startGraphiteListener(t *testing.T, address string, received chan<- string) net.Listener {
+	listener, err := net.Listen("tcp", address)
+	assert.NilError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				received <- scanner.Text()
+			}
+			conn.Close()
+		}
+	}()
+
+	return listener
+}
+
+func TestGraphiteResultWriter_Batching(t *testing.T) {
+	received := make(chan string, 1000)
+	listener := startGraphiteListener(t, "127.0.0.1:0", received)
+	defer listener.Close()
+
+	sinkOpts := getTestSinkOptions()
+	sinkOpts.BatchSize = 2
+
+	resultWriter, err := NewGraphiteResultWriter(context.Background(), &GraphiteOptions{Address: listener.Addr().String(), Prefix: DefaultGraphitePrefix}, sinkOpts)
+	assert.NilError(t, err)
+
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.WriteRun(&RunResult{Timestamp: time.Unix(1700000000, 0), TransportProtocol: "tcp6", Error: "could not fetch metadata"}))
+	assert.NilError(t, resultWriter.Close())
+
+	lines := []string{}
+	for len(lines) < 1+15+17+1 {
+		select {
+		case line := <-received:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d lines received", len(lines))
+		}
+	}
+
+	assert.Equal(t, lines[0], "cfspeed.run.success;protocol=tcp4;colo=NRT;asn=64496;country=JP 1 1711972800")
+	assert.Equal(t, lines[len(lines)-1], "cfspeed.run.success;protocol=tcp6 0 1700000000")
+}
+
+func TestGraphiteResultWriter_SinkDown(t *testing.T) {
+	received := make(chan string, 1000)
+	listener := startGraphiteListener(t, "127.0.0.1:0", received)
+	address := listener.Addr().String()
+	listener.Close()
+
+	resultWriter, err := NewGraphiteResultWriter(context.Background(), &GraphiteOptions{Address: address}, getTestSinkOptions())
+	assert.NilError(t, err)
+
+	err = resultWriter.WriteRun(&RunResult{Timestamp: time.Unix(1700000000, 0), TransportProtocol: "tcp4"})
+	assert.ErrorContains(t, err, "could not send to Graphite; 1 lines retained")
+
+	// lines retained are sent once the sink is back
+	listener = startGraphiteListener(t, address, received)
+	defer listener.Close()
+
+	assert.NilError(t, resultWriter.WriteRun(&RunResult{Timestamp: time.Unix(1700000060, 0), TransportProtocol: "tcp4"}))
+	assert.NilError(t, resultWriter.Close())
+
+	for _, expected := range []string{"run.success;protocol=tcp4 1 1700000000", "run.success;protocol=tcp4 1 1700000060"} {
+		select {
+		case line := <-received:
+			assert.Equal(t, line, expected)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not received", expected)
+		}
+	}
+}
+
+func TestSinkResultWriter_MaxPending(t *testing.T) {
+	sinkOpts := getTestSinkOptions()
+	sinkOpts.MaxRetries = 0
+	sinkOpts.MaxPending = 2
+
+	nAttempts := 0
+	sinkWriter := newSinkResultWriter(context.Background(), "test", getSinkPointsEncodeFunc(encodeInfluxLines), func(lines []string, _ time.Duration) error {
+		nAttempts += 1
+		return io.ErrUnexpectedEOF
+	}, sinkOpts)
+
+	assert.ErrorContains(t, sinkWriter.WriteRun(generateDummyRunResult()), "2 lines retained")
+	assert.Equal(t, nAttempts, 1)
+	assert.Assert(t, strings.HasPrefix(sinkWriter.pending[0], "cfspeed_rtt,"))
+}
+
+func TestSendWithRetries_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	nAttempts := 0
+	startedAt := time.Now()
+	err := sendWithRetries(ctx, 3, time.Hour, func() error {
+		nAttempts += 1
+		cancel()
+		return io.ErrUnexpectedEOF
+	})
+
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, nAttempts, 1)
+	assert.Assert(t, time.Since(startedAt) < time.Minute)
+}
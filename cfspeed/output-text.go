@@ -0,0 +1,214 @@
+package cfspeed
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+type textResultWriter struct {
+	printer *log.Logger
+}
+
+func newTextResultWriter(w io.Writer) *textResultWriter {
+	return &textResultWriter{
+		printer: log.New(w, "", 0),
+	}
+}
+
+func printRunHeading(printer *log.Logger, timestamp time.Time, transportProtocol string) {
+	printer.Println()
+	printer.Printf("At: %s\n", timestamp.Format(time.RFC1123Z))
+	printer.Printf("Protocol: %s\n", transportProtocol)
+	printer.Println()
+}
+
+func printMetadata(printer *log.Logger, metadata *MeasurementMetadata) {
+	if metadata != nil {
+		printer.Printf("SrcIP: %s (AS%s)\n", metadata.SrcIP, metadata.SrcASN)
+		printer.Printf("SrcLocation: %s, %s\n", metadata.SrcCity, metadata.SrcCountry)
+		printer.Printf("DstColocation: %s\n", metadata.DstColo)
+		printConnPhases(printer, "Metadata", metadata.Phases)
+	}
+}
+
+func formatDeciles(deciles []float64) string {
+	numStrs := []string{}
+
+	for _, decile := range deciles {
+		numStrs = append(numStrs, fmt.Sprintf("%.3f", decile))
+	}
+
+	return fmt.Sprintf("%v", numStrs)
+}
+
+func printConnPhases(printer *log.Logger, label string, phases *ConnPhaseStats) {
+	if phases != nil {
+		for _, phase := range phases.getPhases() {
+			printer.Printf("%s-%s-mean: %.3f ms\n", label, phase.label, phase.stats.Mean)
+		}
+		printer.Printf("%s-reused: %d/%d\n", label, phases.NReused, phases.NRequests)
+	}
+}
+
+func printRTTMeasurement(printer *log.Logger, label string, measurement *Stats) {
+	if measurement != nil {
+		printer.Printf("%s-mean: %.3f ms\n", label, measurement.Mean)
+		printer.Printf("%s-stderr: %.3f ms\n", label, measurement.StdErr)
+		printer.Printf("%s-min: %.3f ms\n", label, measurement.Min)
+		printer.Printf("%s-max: %.3f ms\n", label, measurement.Max)
+		printer.Printf("%s-deciles: %s ms\n", label, formatDeciles(measurement.Deciles))
+		printer.Printf("%s-jitter: %.3f ms\n", label, measurement.Jitter)
+		printer.Printf("%s-n: %d\n", label, measurement.NSamples)
+		printConnPhases(printer, label, measurement.Phases)
+	}
+}
+
+func printSpeedMeasurement(printer *log.Logger, label string, measurement *SpeedMeasurementStats) {
+	if measurement != nil {
+		printer.Printf("%s-mean: %.3f Mbps\n", label, measurement.Mean)
+		printer.Printf("%s-stderr: %.3f Mbps\n", label, measurement.StdErr)
+		printer.Printf("%s-min: %.3f Mbps\n", label, measurement.Min)
+		printer.Printf("%s-max: %.3f Mbps\n", label, measurement.Max)
+		printer.Printf("%s-deciles: %s Mbps\n", label, formatDeciles(measurement.Deciles))
+		printer.Printf("%s-cat: %.3f Mbps\n", label, measurement.CatSpeed)
+		printer.Printf("%s-tx: %.3f MiB\n", label, float64(measurement.TXSize)/1024/1024)
+		printer.Printf("%s-mx: %d\n", label, measurement.Multiplicity)
+		printer.Printf("%s-n: %d\n", label, measurement.NSamples)
+		printConnPhases(printer, label, measurement.Phases)
+		if measurement.Truncated {
+			printer.Printf("%s-truncated: data budget exhausted\n", label)
+		}
+	}
+}
+
+func printResponsiveness(printer *log.Logger, responsiveness *ResponsivenessStats) {
+	printer.Printf("RPM: %.0f\n", responsiveness.RPM)
+	printer.Printf("RPM-confidence: %s\n", responsiveness.Confidence)
+	printer.Printf("RPM-connections: %d\n", responsiveness.Connections)
+	printer.Printf("RPM-downlink: %.3f Mbps\n", responsiveness.DownlinkMBPS)
+	printer.Printf("RPM-uplink: %.3f Mbps\n", responsiveness.UplinkMBPS)
+	for _, component := range []struct {
+		label string
+		stats *Stats
+	}{
+		{"RPM-ForeignTCP", responsiveness.ForeignTCP},
+		{"RPM-ForeignTLS", responsiveness.ForeignTLS},
+		{"RPM-ForeignHTTP", responsiveness.ForeignHTTP},
+		{"RPM-SelfHTTP", responsiveness.SelfHTTP},
+	} {
+		if component.stats != nil {
+			printer.Printf("%s-mean: %.3f ms\n", component.label, component.stats.Mean)
+		}
+	}
+	if responsiveness.Truncated {
+		printer.Printf("RPM-truncated: data budget exhausted\n")
+	}
+}
+
+func printScores(printer *log.Logger, scores *Scores) {
+	if scores.Bufferbloat != "" {
+		printer.Printf("Bufferbloat: %s (latency increase %.3f ms)\n", scores.Bufferbloat, scores.LatencyIncrease)
+	}
+	for _, useCase := range scores.getSortedUseCases() {
+		printer.Printf("Rating-%s: %s\n", useCase, scores.UseCases[useCase])
+	}
+}
+
+func printThresholdChecks(printer *log.Logger, checks []*ThresholdCheck) {
+	for _, check := range checks {
+		verdict := "PASS"
+		if !check.Passed {
+			verdict = "FAIL"
+		}
+
+		if check.Measured {
+			printer.Printf("Check-%s: %s (%.3f %s against %.3f %s)\n", check.Name, verdict, check.Observed, check.Unit, check.Limit, check.Unit)
+		} else {
+			printer.Printf("Check-%s: SKIP (not measured)\n", check.Name)
+		}
+	}
+}
+
+func (t *textResultWriter) WriteRun(run *RunResult) error {
+	printRunHeading(t.printer, run.Timestamp, run.TransportProtocol)
+
+	// a blank line follows every completed phase up to downlink
+	if run.Metadata == nil {
+		return nil
+	}
+	printMetadata(t.printer, run.Metadata)
+	t.printer.Println()
+
+	if run.UnloadedRTT != nil {
+		printRTTMeasurement(t.printer, "RTT-Unloaded", run.UnloadedRTT)
+		t.printer.Println()
+	}
+
+	if run.Downlink != nil {
+		printSpeedMeasurement(t.printer, "Downlink", run.Downlink)
+		if run.DownlinkLoadedRTT != nil {
+			t.printer.Println()
+			printRTTMeasurement(t.printer, "RTT-DownlinkLoaded", run.DownlinkLoadedRTT)
+		}
+		t.printer.Println()
+	}
+
+	// a blank line separates the sections from here on, without any trailing one
+	separated := true
+	separate := func() {
+		if !separated {
+			t.printer.Println()
+		}
+		separated = false
+	}
+
+	if run.Uplink != nil {
+		separate()
+		printSpeedMeasurement(t.printer, "Uplink", run.Uplink)
+		if run.UplinkLoadedRTT != nil {
+			t.printer.Println()
+			printRTTMeasurement(t.printer, "RTT-UplinkLoaded", run.UplinkLoadedRTT)
+		}
+	}
+
+	if run.BidirectionalDownlink != nil && run.BidirectionalUplink != nil {
+		separate()
+		printSpeedMeasurement(t.printer, "Bidirectional-Downlink", run.BidirectionalDownlink)
+		t.printer.Println()
+		printSpeedMeasurement(t.printer, "Bidirectional-Uplink", run.BidirectionalUplink)
+		if run.BidirectionalLoadedRTT != nil {
+			t.printer.Println()
+			printRTTMeasurement(t.printer, "RTT-BidirectionalLoaded", run.BidirectionalLoadedRTT)
+		}
+	}
+
+	if run.Responsiveness != nil {
+		separate()
+		printResponsiveness(t.printer, run.Responsiveness)
+	}
+
+	if run.Scores != nil && (run.Scores.Bufferbloat != "" || len(run.Scores.UseCases) > 0) {
+		separate()
+		printScores(t.printer, run.Scores)
+	}
+
+	if len(run.Checks) > 0 {
+		separate()
+		printThresholdChecks(t.printer, run.Checks)
+	}
+
+	if len(run.Warnings) > 0 {
+		separate()
+		for _, warning := range run.Warnings {
+			t.printer.Printf("Warning: %s\n", warning)
+		}
+	}
+
+	return nil
+}
+
+func (t *textResultWriter) Close() error {
+	return nil
+}
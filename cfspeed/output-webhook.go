@@ -0,0 +1,223 @@
+package cfspeed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	WebhookConditionAlways = "always" // Every run
+	WebhookConditionBreach = "breach" // Runs failing to meet thresholds
+	WebhookConditionError  = "error"  // Runs failing with errors
+
+	defaultWebhookContentType = "application/json"
+)
+
+// WebhookOptions configures notifications of runs over HTTP POST
+type WebhookOptions struct {
+	URL           string
+	Template      string            // text/template rendering the body from a RunResult; the run in JSON if empty
+	ContentType   string            // Content-Type of the body
+	Header        map[string]string // Additional HTTP headers such as for authentication
+	Conditions    []string          // Runs are notified if any of them is met; every run is if neither they nor When are given
+	When          *Thresholds       // Levels of metrics of which runs breaching any are notified, independent of thresholds of runs; nil for none
+	MaxRetries    int               // Number of retries of a failed notification
+	RetryInterval time.Duration     // Delay before the first retry, doubled on every retry
+	Timeout       time.Duration     // Timeout of each attempt to notify, independent of that of runs
+}
+
+func NewWebhookOptions() *WebhookOptions {
+	return &WebhookOptions{
+		ContentType:   defaultWebhookContentType,
+		Header:        map[string]string{},
+		Conditions:    []string{},
+		MaxRetries:    defaultSinkMaxRetries,
+		RetryInterval: defaultSinkRetryInterval,
+		Timeout:       defaultSinkTimeout,
+	}
+}
+
+func (o *WebhookOptions) Validate() error {
+	parsedURL, err := url.Parse(o.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return fmt.Errorf(`invalid webhook URL "%s"; it needs to be an absolute HTTP(S) URL`, o.URL)
+	}
+	for _, condition := range o.Conditions {
+		switch condition {
+		case WebhookConditionAlways, WebhookConditionBreach, WebhookConditionError:
+		default:
+			return fmt.Errorf(`invalid webhook condition "%s"; it needs to be one of always, breach and error`, condition)
+		}
+	}
+	if o.MaxRetries < 0 {
+		return fmt.Errorf(`invalid number of retries "%d"; it needs to be a non-negative integer`, o.MaxRetries)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf(`invalid timeout "%s"; it needs to be positive`, o.Timeout)
+	}
+
+	if o.When != nil {
+		if err := o.When.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseWebhookWhen parses comma-separated conditions on metrics, e.g. "down<100,rtt>50", into the levels whose breach they denote.
+// Means of downlink and uplink speed in Mbps are compared with "<", and means of unloaded and loaded RTT in ms with ">".
+func ParseWebhookWhen(expr string) (*Thresholds, error) {
+	when := &Thresholds{SpeedStatistic: SpeedStatisticMean}
+
+	for _, condition := range strings.Split(expr, ",") {
+		condition = strings.TrimSpace(condition)
+
+		index := strings.IndexAny(condition, "<>")
+		if index < 0 {
+			return nil, fmt.Errorf(`invalid webhook condition "%s"; it needs to be one of down<Mbps, up<Mbps, rtt>ms and loaded-rtt>ms`, condition)
+		}
+
+		limit, err := strconv.ParseFloat(strings.TrimSpace(condition[index+1:]), 64)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf(`invalid limit of webhook condition "%s"; it needs to be a positive number`, condition)
+		}
+
+		switch strings.TrimSpace(condition[:index+1]) {
+		case "down<":
+			when.MinDownlink = limit
+		case "up<":
+			when.MinUplink = limit
+		case "rtt>":
+			when.MaxRTT = limit
+		case "loaded-rtt>":
+			when.MaxLoadedRTT = limit
+		default:
+			return nil, fmt.Errorf(`invalid webhook condition "%s"; it needs to be one of down<Mbps, up<Mbps, rtt>ms and loaded-rtt>ms`, condition)
+		}
+	}
+
+	return when, nil
+}
+
+// breaches tells whether any of the metrics of the run measured breaches its level
+func (o *WebhookOptions) breaches(run *RunResult) bool {
+	for _, check := range o.When.Evaluate(run) {
+		if check.Measured && !check.Passed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *WebhookOptions) matches(run *RunResult) bool {
+	if len(o.Conditions) == 0 && o.When == nil {
+		return true
+	}
+
+	for _, condition := range o.Conditions {
+		switch {
+		case condition == WebhookConditionAlways,
+			condition == WebhookConditionBreach && !run.ChecksPassed(),
+			condition == WebhookConditionError && run.Error != "":
+			return true
+		}
+	}
+
+	return o.When != nil && o.breaches(run)
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	// json renders a value in JSON, e.g. to embed text in a JSON body safely
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+type webhookResultWriter struct {
+	ctx        context.Context // Bounds waiting between retries
+	opts       WebhookOptions
+	template   *template.Template
+	header     http.Header
+	httpClient *http.Client
+}
+
+// NewWebhookResultWriter posts runs meeting the conditions given to a URL, in JSON or rendered by a template
+func NewWebhookResultWriter(ctx context.Context, opts *WebhookOptions) (ResultWriter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var bodyTemplate *template.Template = nil
+	if opts.Template != "" {
+		parsedTemplate, err := template.New("webhook").Funcs(webhookTemplateFuncs).Parse(opts.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template: %w", err)
+		}
+		bodyTemplate = parsedTemplate
+	}
+
+	header := http.Header{}
+	for key, value := range opts.Header {
+		header.Set(key, value)
+	}
+	header.Set("Content-Type", opts.ContentType)
+
+	return &webhookResultWriter{
+		ctx:        ctx,
+		opts:       *opts,
+		template:   bodyTemplate,
+		header:     header,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+func (w *webhookResultWriter) getBody(run *RunResult) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(&HistoryRecord{
+			SchemaVersion: ResultSchemaVersion,
+			RunResult:     run,
+		})
+	}
+
+	body := &bytes.Buffer{}
+	if err := w.template.Execute(body, run); err != nil {
+		return nil, fmt.Errorf("could not render webhook template: %w", err)
+	}
+
+	return body.Bytes(), nil
+}
+
+func (w *webhookResultWriter) WriteRun(run *RunResult) error {
+	if !w.opts.matches(run) {
+		return nil
+	}
+
+	body, err := w.getBody(run)
+	if err != nil {
+		return err
+	}
+
+	err = sendWithRetries(w.ctx, w.opts.MaxRetries, w.opts.RetryInterval, func() error {
+		return postSinkPayload(w.httpClient, w.opts.URL, w.header, body, w.opts.Timeout)
+	})
+	if err != nil {
+		return fmt.Errorf("could not notify webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (w *webhookResultWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,185 @@
+package cfspeed
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+type webhookTestRequest struct {
+	contentType string
+	body        string
+}
+
+func startDummyWebhook(t *testing.T, nFailures int) (string, func() []*webhookTestRequest) {
+	mutex := &sync.Mutex{}
+	nRequests := 0
+	requests := []*webhookTestRequest{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		nRequests += 1
+		if nRequests <= nFailures {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, &webhookTestRequest{
+			contentType: r.Header.Get("Content-Type"),
+			body:        string(body),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, func() []*webhookTestRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return requests
+	}
+}
+
+func getTestWebhookOptions(url string) *WebhookOptions {
+	opts := NewWebhookOptions()
+	opts.URL = url
+	opts.RetryInterval = 0
+
+	return opts
+}
+
+func TestWebhookResultWriter_JSON(t *testing.T) {
+	url, getRequests := startDummyWebhook(t, 2)
+
+	resultWriter, err := NewWebhookResultWriter(context.Background(), getTestWebhookOptions(url))
+	assert.NilError(t, err)
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.Close())
+
+	requests := getRequests()
+	assert.Equal(t, len(requests), 1)
+	assert.Equal(t, requests[0].contentType, "application/json")
+
+	record := &HistoryRecord{}
+	assert.NilError(t, json.Unmarshal([]byte(requests[0].body), record))
+	assert.Equal(t, record.SchemaVersion, ResultSchemaVersion)
+	assert.DeepEqual(t, record.RunResult, generateDummyRunResult())
+}
+
+func TestWebhookResultWriter_Template(t *testing.T) {
+	url, getRequests := startDummyWebhook(t, 0)
+
+	opts := getTestWebhookOptions(url)
+	opts.Template = `{"text": {{printf "%s at %s: %.1f Mbps down" .TransportProtocol .Metadata.DstColo .Downlink.Mean | json}}}`
+
+	resultWriter, err := NewWebhookResultWriter(context.Background(), opts)
+	assert.NilError(t, err)
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+
+	requests := getRequests()
+	assert.Equal(t, len(requests), 1)
+	assert.Equal(t, requests[0].body, `{"text": "tcp4 at NRT: 100.0 Mbps down"}`)
+}
+
+func TestWebhookResultWriter_Conditions(t *testing.T) {
+	url, getRequests := startDummyWebhook(t, 0)
+
+	opts := getTestWebhookOptions(url)
+	opts.Conditions = []string{WebhookConditionBreach, WebhookConditionError}
+
+	resultWriter, err := NewWebhookResultWriter(context.Background(), opts)
+	assert.NilError(t, err)
+
+	passedRun := generateDummyRunResult()
+	passedRun.Checks = []*ThresholdCheck{{Name: "min-down", Measured: true, Passed: true}}
+	breachedRun := generateDummyRunResult()
+	breachedRun.Checks = []*ThresholdCheck{{Name: "min-down", Measured: true, Passed: false}}
+	failedRun := &RunResult{TransportProtocol: "tcp6", Error: "could not fetch metadata"}
+
+	for _, run := range []*RunResult{passedRun, breachedRun, generateDummyRunResult(), failedRun} {
+		assert.NilError(t, resultWriter.WriteRun(run))
+	}
+
+	requests := getRequests()
+	assert.Equal(t, len(requests), 2)
+
+	record := &HistoryRecord{}
+	assert.NilError(t, json.Unmarshal([]byte(requests[1].body), record))
+	assert.Equal(t, record.Error, "could not fetch metadata")
+}
+
+func TestWebhookResultWriter_Unavailable(t *testing.T) {
+	url, getRequests := startDummyWebhook(t, 10)
+
+	opts := getTestWebhookOptions(url)
+	opts.MaxRetries = 2
+
+	resultWriter, err := NewWebhookResultWriter(context.Background(), opts)
+	assert.NilError(t, err)
+	assert.ErrorContains(t, resultWriter.WriteRun(generateDummyRunResult()), "could not notify webhook: unexpected status 502 Bad Gateway")
+	assert.Equal(t, len(getRequests()), 0)
+}
+
+func TestNewWebhookResultWriter_Invalid(t *testing.T) {
+	opts := getTestWebhookOptions("http://localhost:8080/hook")
+	opts.Conditions = []string{"sometimes"}
+	_, err := NewWebhookResultWriter(context.Background(), opts)
+	assert.ErrorContains(t, err, `invalid webhook condition "sometimes"`)
+
+	opts = getTestWebhookOptions("http://localhost:8080/hook")
+	opts.Template = "{{.Nonexistent"
+	_, err = NewWebhookResultWriter(context.Background(), opts)
+	assert.ErrorContains(t, err, "invalid webhook template")
+
+	_, err = NewWebhookResultWriter(context.Background(), getTestWebhookOptions("/hook"))
+	assert.ErrorContains(t, err, `invalid webhook URL "/hook"`)
+}
+
+func TestParseWebhookWhen(t *testing.T) {
+	when, err := ParseWebhookWhen("down<100, rtt>50,loaded-rtt>200,up<10")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, when, &Thresholds{MinDownlink: 100, MinUplink: 10, MaxRTT: 50, MaxLoadedRTT: 200, SpeedStatistic: SpeedStatisticMean})
+
+	for _, expr := range []string{"down>100", "rtt<50", "jitter>5", "down<", "down<-1", "down"} {
+		_, err := ParseWebhookWhen(expr)
+		assert.ErrorContains(t, err, "webhook condition", expr)
+	}
+}
+
+func TestWebhookResultWriter_When(t *testing.T) {
+	url, getRequests := startDummyWebhook(t, 0)
+
+	opts := getTestWebhookOptions(url)
+	when, err := ParseWebhookWhen("down<100,rtt>50")
+	assert.NilError(t, err)
+	opts.When = when
+
+	resultWriter, err := NewWebhookResultWriter(context.Background(), opts)
+	assert.NilError(t, err)
+
+	slowRun := generateDummyRunResult()
+	slowRun.Downlink.Mean = 80
+	// checks of the run, e.g. by --min-down, are irrelevant to the levels of the webhook
+	passedRun := generateDummyRunResult()
+	passedRun.Checks = []*ThresholdCheck{{Name: "min-down", Measured: true, Passed: false}}
+	pingRun := &RunResult{TransportProtocol: "tcp6", UnloadedRTT: getF64Stats([]float64{60})}
+
+	for _, run := range []*RunResult{slowRun, passedRun, pingRun} {
+		assert.NilError(t, resultWriter.WriteRun(run))
+	}
+
+	requests := getRequests()
+	assert.Equal(t, len(requests), 2)
+
+	record := &HistoryRecord{}
+	assert.NilError(t, json.Unmarshal([]byte(requests[1].body), record))
+	assert.Equal(t, record.TransportProtocol, "tcp6")
+}
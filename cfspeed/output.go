@@ -0,0 +1,158 @@
+package cfspeed
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	FormatText       = "text"
+	FormatJSON       = "json"
+	FormatPrometheus = "prometheus"
+	FormatCSV        = "csv"
+	FormatNDJSON     = "ndjson"
+)
+
+// ResultWriter renders results of measurement runs in a specific format.
+// WriteRun is called once per run, and Close is called after the last run to finalise the output.
+type ResultWriter interface {
+	WriteRun(run *RunResult) error
+	Close() error
+}
+
+func NewResultWriter(format string, w io.Writer) (ResultWriter, error) {
+	switch format {
+	case FormatText:
+		return newTextResultWriter(w), nil
+	case FormatJSON:
+		return newJSONResultWriter(w), nil
+	case FormatPrometheus:
+		return newPromResultWriter(w), nil
+	case FormatCSV:
+		return newCSVResultWriter(w), nil
+	case FormatNDJSON:
+		return newNDJSONResultWriter(w), nil
+	default:
+		return nil, fmt.Errorf(`unknown output format "%s"`, format)
+	}
+}
+
+type multiResultWriter struct {
+	writers []ResultWriter
+}
+
+// NewMultiResultWriter duplicates runs to all the writers given.
+// Every writer is called even if another fails, and the first error is returned.
+func NewMultiResultWriter(writers ...ResultWriter) ResultWriter {
+	return &multiResultWriter{
+		writers: writers,
+	}
+}
+
+func (m *multiResultWriter) WriteRun(run *RunResult) error {
+	var firstErr error = nil
+
+	for _, writer := range m.writers {
+		if err := writer.WriteRun(run); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (m *multiResultWriter) Close() error {
+	var firstErr error = nil
+
+	for _, writer := range m.writers {
+		if err := writer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+type reportingResultWriter struct {
+	writer ResultWriter
+	report func(err error)
+}
+
+// NewReportingResultWriter hands errors of the writer to report instead of returning them.
+// It suits writers whose failures must not be taken for failures of runs, e.g. those pushing results to remote sinks.
+func NewReportingResultWriter(writer ResultWriter, report func(err error)) ResultWriter {
+	return &reportingResultWriter{
+		writer: writer,
+		report: report,
+	}
+}
+
+func (r *reportingResultWriter) WriteRun(run *RunResult) error {
+	if err := r.writer.WriteRun(run); err != nil {
+		r.report(err)
+	}
+
+	return nil
+}
+
+func (r *reportingResultWriter) Close() error {
+	if err := r.writer.Close(); err != nil {
+		r.report(err)
+	}
+
+	return nil
+}
+
+type asyncResultWriter struct {
+	writer ResultWriter
+	report func(err error)
+	queue  chan *RunResult
+	done   chan struct{}
+}
+
+// NewAsyncResultWriter hands runs over to the writer in the background, so that slow writers, e.g. those retrying remote sinks, do not hold up runs.
+// Up to queueSize runs wait to be written, beyond which the oldest are dropped. Errors, including the drops, are handed to report.
+// Close waits for the runs queued to be written.
+func NewAsyncResultWriter(writer ResultWriter, queueSize int, report func(err error)) ResultWriter {
+	a := &asyncResultWriter{
+		writer: NewReportingResultWriter(writer, report),
+		report: report,
+		queue:  make(chan *RunResult, queueSize),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(a.done)
+
+		for run := range a.queue {
+			a.writer.WriteRun(run)
+		}
+	}()
+
+	return a
+}
+
+func (a *asyncResultWriter) WriteRun(run *RunResult) error {
+	for {
+		select {
+		case a.queue <- run:
+			return nil
+		default:
+		}
+
+		// the oldest run queued makes room for the latest, as results of sinks are more useful the fresher they are
+		select {
+		case dropped := <-a.queue:
+			a.report(fmt.Errorf("dropped the result of the run at %s as the queue is full", dropped.Timestamp.Format(time.RFC3339)))
+		default:
+		}
+	}
+}
+
+func (a *asyncResultWriter) Close() error {
+	close(a.queue)
+	<-a.done
+
+	return a.writer.Close()
+}
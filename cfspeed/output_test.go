@@ -0,0 +1,295 @@
+package cfspeed
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func generateDummyRunResult() *RunResult {
+	return &RunResult{
+		Timestamp:         time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC),
+		TransportProtocol: "tcp4",
+		Metadata: &MeasurementMetadata{
+			SrcIP:      "192.0.2.1",
+			SrcASN:     "64496",
+			SrcCity:    "Tokyo",
+			SrcCountry: "JP",
+			DstColo:    "NRT",
+		},
+		UnloadedRTT: getF64Stats([]float64{10, 12, 14}),
+		Downlink: &SpeedMeasurementStats{
+			NSamples:     3,
+			TXSize:       3 * 1024 * 1024,
+			Multiplicity: 1,
+			Mean:         100,
+			Min:          90,
+			Max:          110,
+			Deciles:      []float64{90, 90, 90, 100, 100, 100, 110, 110, 110},
+			CatSpeed:     99,
+		},
+	}
+}
+
+func TestTextResultWriter_PartialRun(t *testing.T) {
+	buf := &bytes.Buffer{}
+	resultWriter, err := NewResultWriter(FormatText, buf)
+	assert.NilError(t, err)
+
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.Close())
+
+	assert.Equal(t, buf.String(), `
+At: Mon, 01 Apr 2024 12:00:00 +0000
+Protocol: tcp4
+
+SrcIP: 192.0.2.1 (AS64496)
+SrcLocation: Tokyo, JP
+DstColocation: NRT
+
+RTT-Unloaded-mean: 12.000 ms
+RTT-Unloaded-stderr: 0.943 ms
+RTT-Unloaded-min: 10.000 ms
+RTT-Unloaded-max: 14.000 ms
+RTT-Unloaded-deciles: [10.000 10.000 12.000 12.000 12.000 12.000 12.000 14.000 14.000] ms
+RTT-Unloaded-jitter: 2.000 ms
+RTT-Unloaded-n: 3
+
+Downlink-mean: 100.000 Mbps
+Downlink-stderr: 0.000 Mbps
+Downlink-min: 90.000 Mbps
+Downlink-max: 110.000 Mbps
+Downlink-deciles: [90.000 90.000 90.000 100.000 100.000 100.000 110.000 110.000 110.000] Mbps
+Downlink-cat: 99.000 Mbps
+Downlink-tx: 3.000 MiB
+Downlink-mx: 1
+Downlink-n: 3
+
+`)
+}
+
+func TestJSONResultWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	resultWriter, err := NewResultWriter(FormatJSON, buf)
+	assert.NilError(t, err)
+
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.WriteRun(&RunResult{TransportProtocol: "tcp6", Error: "could not fetch metadata"}))
+	assert.NilError(t, resultWriter.Close())
+
+	report := &Report{}
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), report))
+
+	assert.Equal(t, report.SchemaVersion, ResultSchemaVersion)
+	assert.Equal(t, len(report.Runs), 2)
+	assert.DeepEqual(t, report.Runs[0], generateDummyRunResult())
+	assert.Equal(t, report.Runs[1].Metadata, (*MeasurementMetadata)(nil))
+	assert.Equal(t, report.Runs[1].Error, "could not fetch metadata")
+}
+
+func TestJSONResultWriter_RTTSamples(t *testing.T) {
+	run := generateDummyRunResult()
+	run.UnloadedRTT = getF64SamplesStats([]float64{14, 10, 12})
+
+	buf := &bytes.Buffer{}
+	resultWriter, err := NewResultWriter(FormatNDJSON, buf)
+	assert.NilError(t, err)
+	assert.NilError(t, resultWriter.WriteRun(run))
+	assert.NilError(t, resultWriter.Close())
+
+	assert.Assert(t, strings.Contains(buf.String(), `"jitter":3,"samples":[14,10,12]`))
+}
+
+func TestNDJSONResultWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	resultWriter, err := NewResultWriter(FormatNDJSON, buf)
+	assert.NilError(t, err)
+
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.WriteRun(&RunResult{TransportProtocol: "tcp6", Error: "could not fetch metadata"}))
+	assert.NilError(t, resultWriter.Close())
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Equal(t, len(lines), 2)
+
+	record := &HistoryRecord{}
+	assert.NilError(t, json.Unmarshal([]byte(lines[0]), record))
+	assert.Equal(t, record.SchemaVersion, ResultSchemaVersion)
+	assert.DeepEqual(t, record.RunResult, generateDummyRunResult())
+
+	record = &HistoryRecord{}
+	assert.NilError(t, json.Unmarshal([]byte(lines[1]), record))
+	assert.Equal(t, record.Error, "could not fetch metadata")
+}
+
+func TestCSVResultWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	resultWriter, err := NewResultWriter(FormatCSV, buf)
+	assert.NilError(t, err)
+
+	failedRun := &RunResult{Timestamp: time.Unix(1700000000, 0).UTC(), TransportProtocol: "tcp6", Error: "could not fetch metadata"}
+	passedRun := generateDummyRunResult()
+	passedRun.Checks = []*ThresholdCheck{{Name: "min-down", Measured: true, Passed: true}}
+
+	assert.NilError(t, resultWriter.WriteRun(passedRun))
+	assert.NilError(t, resultWriter.WriteRun(failedRun))
+	assert.NilError(t, resultWriter.Close())
+
+	records, err := csv.NewReader(buf).ReadAll()
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 3)
+
+	header := records[0]
+	assert.DeepEqual(t, header[:7], []string{"timestamp", "transportProtocol", "srcIP", "srcASN", "srcCity", "srcCountry", "dstColo"})
+	assert.DeepEqual(t, header[len(header)-3:], []string{"checksPassed", "warnings", "error"})
+	assert.Equal(t, len(records[1]), len(header))
+	assert.Equal(t, len(records[2]), len(header))
+
+	// columns are grouped by phase, e.g. jitter and phases of RTT next to its deciles
+	indices := map[string]int{}
+	for index, name := range header {
+		indices[name] = index
+	}
+	assert.Equal(t, indices["unloadedRTT.jitter"], indices["unloadedRTT.d9"]+1)
+	assert.Equal(t, indices["unloadedRTT.phases.dns"], indices["unloadedRTT.jitter"]+1)
+	assert.Equal(t, indices["downlink.truncated"], indices["downlink.catSpeed"]+1)
+	assert.Equal(t, indices["downlinkLoadedRTT.nSamples"], indices["downlink.phases.requestsReused"]+1)
+	assert.Equal(t, indices["metadata.phases.dns"], indices["dstColo"]+1)
+
+	fields := map[string]string{}
+	for index, name := range header {
+		fields[name] = records[1][index]
+	}
+	assert.Equal(t, fields["timestamp"], "2024-04-01T12:00:00Z")
+	assert.Equal(t, fields["dstColo"], "NRT")
+	assert.Equal(t, fields["unloadedRTT.mean"], "12")
+	assert.Equal(t, fields["downlink.txSize"], "3145728")
+	assert.Equal(t, fields["downlink.d1"], "90")
+	assert.Equal(t, fields["downlink.d9"], "110")
+	assert.Equal(t, fields["downlink.catSpeed"], "99")
+	assert.Equal(t, fields["uplink.mean"], "")
+	assert.Equal(t, fields["checksPassed"], "true")
+	assert.Equal(t, fields["downlink.truncated"], "false")
+
+	for index, name := range header {
+		fields[name] = records[2][index]
+	}
+	assert.Equal(t, fields["transportProtocol"], "tcp6")
+	assert.Equal(t, fields["srcIP"], "")
+	assert.Equal(t, fields["downlink.d5"], "")
+	assert.Equal(t, fields["checksPassed"], "")
+	assert.Equal(t, fields["error"], "could not fetch metadata")
+}
+
+func TestCSVResultWriter_NoRuns(t *testing.T) {
+	buf := &bytes.Buffer{}
+	resultWriter, err := NewResultWriter(FormatCSV, buf)
+	assert.NilError(t, err)
+	assert.NilError(t, resultWriter.Close())
+
+	records, err := csv.NewReader(buf).ReadAll()
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 1)
+	assert.DeepEqual(t, records[0], getCSVHeader())
+}
+
+func TestNewResultWriter_UnknownFormat(t *testing.T) {
+	_, err := NewResultWriter("xml", &bytes.Buffer{})
+	assert.ErrorContains(t, err, `unknown output format "xml"`)
+}
+
+type failingResultWriter struct{}
+
+func (f *failingResultWriter) WriteRun(_ *RunResult) error {
+	return errors.New("sink unavailable")
+}
+
+func (f *failingResultWriter) Close() error {
+	return errors.New("sink unavailable on close")
+}
+
+func TestReportingResultWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	reported := []string{}
+	resultWriter := NewMultiResultWriter(newNDJSONResultWriter(buf), NewReportingResultWriter(&failingResultWriter{}, func(err error) {
+		reported = append(reported, err.Error())
+	}))
+
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.Close())
+	assert.DeepEqual(t, reported, []string{"sink unavailable", "sink unavailable on close"})
+	assert.Equal(t, strings.Count(buf.String(), "\n"), 1)
+}
+
+func TestPromResultWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	resultWriter, err := NewResultWriter(FormatPrometheus, buf)
+	assert.NilError(t, err)
+
+	assert.NilError(t, resultWriter.WriteRun(generateDummyRunResult()))
+	assert.NilError(t, resultWriter.WriteRun(&RunResult{Timestamp: time.Unix(1700000000, 0), TransportProtocol: "tcp6", Error: "could not fetch metadata"}))
+	assert.NilError(t, resultWriter.Close())
+
+	output := buf.String()
+
+	assert.Assert(t, strings.Contains(output, "# HELP cfspeed_run_success Whether the run completed without errors\n# TYPE cfspeed_run_success gauge\ncfspeed_run_success{transport_protocol=\"tcp4\"} 1\ncfspeed_run_success{transport_protocol=\"tcp6\"} 0\n"))
+	assert.Assert(t, strings.Contains(output, `cfspeed_metadata_info{transport_protocol="tcp4",src_ip="192.0.2.1",src_asn="64496",src_city="Tokyo",src_country="JP",dst_colo="NRT"} 1`+"\n"))
+	assert.Assert(t, strings.Contains(output, `cfspeed_speed_mean_bits_per_second{transport_protocol="tcp4",direction="down"} 1e+08`+"\n"))
+	assert.Assert(t, strings.Contains(output, `cfspeed_speed_bits_per_second{transport_protocol="tcp4",direction="down",quantile="0.9"} 1.1e+08`+"\n"))
+	assert.Assert(t, strings.Contains(output, `cfspeed_rtt_mean_seconds{transport_protocol="tcp4",load="unloaded"} 0.012`+"\n"))
+	assert.Equal(t, strings.Count(output, "# TYPE cfspeed_run_timestamp_seconds gauge\n"), 1)
+}
+
+// blockingResultWriter holds up every run until released
+type blockingResultWriter struct {
+	release chan struct{}
+	written []*RunResult
+}
+
+func (b *blockingResultWriter) WriteRun(run *RunResult) error {
+	<-b.release
+	b.written = append(b.written, run)
+	return nil
+}
+
+func (b *blockingResultWriter) Close() error {
+	return nil
+}
+
+func TestAsyncResultWriter(t *testing.T) {
+	writer := &blockingResultWriter{release: make(chan struct{})}
+	reported := make(chan string, 10)
+	resultWriter := NewAsyncResultWriter(writer, 1, func(err error) {
+		reported <- err.Error()
+	})
+
+	runs := []*RunResult{}
+	for index := 0; index < 3; index += 1 {
+		run := generateDummyRunResult()
+		run.Timestamp = run.Timestamp.Add(time.Duration(index) * time.Minute)
+		runs = append(runs, run)
+	}
+
+	// the first run is taken by the writer held up, the second waits in the queue and the third takes its place
+	assert.NilError(t, resultWriter.WriteRun(runs[0]))
+	poll.WaitOn(t, func(_ poll.LogT) poll.Result {
+		if len(resultWriter.(*asyncResultWriter).queue) > 0 {
+			return poll.Continue("the first run is still queued")
+		}
+		return poll.Success()
+	})
+	assert.NilError(t, resultWriter.WriteRun(runs[1]))
+	assert.NilError(t, resultWriter.WriteRun(runs[2]))
+	assert.Equal(t, <-reported, "dropped the result of the run at 2024-04-01T12:01:00Z as the queue is full")
+
+	close(writer.release)
+	assert.NilError(t, resultWriter.Close())
+	assert.DeepEqual(t, writer.written, []*RunResult{runs[0], runs[2]})
+}
@@ -0,0 +1,132 @@
+package cfspeed
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PhaseMetadata       = "metadata"
+	PhaseRTT            = "RTT"
+	PhaseDownlink       = "downlink"
+	PhaseUplink         = "uplink"
+	PhaseBidirectional  = "bidirectional"
+	PhaseResponsiveness = "responsiveness"
+
+	progressInterval = 250 * time.Millisecond // Interval of progress reports
+	progressWindow   = 1 * time.Second        // Width of the window over which rolling throughput is estimated
+)
+
+// ProgressCounter counts bytes transferred by measurements made with a context carrying it.
+// It is safe for concurrent use as multiplexed measurements share a counter.
+type ProgressCounter struct {
+	bytes  atomic.Int64
+	parent *ProgressCounter // Counter bytes are passed on to; nil for none
+}
+
+func (c *ProgressCounter) add(size int) {
+	if c != nil {
+		c.bytes.Add(int64(size))
+		c.parent.add(size)
+	}
+}
+
+func (c *ProgressCounter) Bytes() int64 {
+	return c.bytes.Load()
+}
+
+type progressCounterKey struct{}
+
+// WithProgressCounter returns a context making measurements count bytes transferred with the counter given
+func WithProgressCounter(ctx context.Context, counter *ProgressCounter) context.Context {
+	return context.WithValue(ctx, progressCounterKey{}, counter)
+}
+
+func getProgressCounter(ctx context.Context) *ProgressCounter {
+	counter, _ := ctx.Value(progressCounterKey{}).(*ProgressCounter)
+	return counter
+}
+
+// ProgressEvent tells how a phase of a run is going
+type ProgressEvent struct {
+	Phase   string
+	Elapsed time.Duration // Time since the phase started
+	Bytes   int64         // Bytes transferred in the phase
+	MBPS    float64       // Throughput over the last second or so
+	Done    bool          // Whether the phase has ended, successfully or not
+}
+
+// ProgressFunc receives progress of runs; calls are never made concurrently
+type ProgressFunc func(event *ProgressEvent)
+
+type progressSnapshot struct {
+	timestamp time.Time
+	bytes     int64
+}
+
+// startProgress reports progress of a phase periodically until the function returned is called, which reports the end of the phase.
+// The context returned makes measurements count bytes for the reports.
+func startProgress(ctx context.Context, phase string, report ProgressFunc) (context.Context, func()) {
+	if report == nil {
+		return ctx, func() {}
+	}
+
+	counter := &ProgressCounter{}
+	start := time.Now()
+	snapshots := []*progressSnapshot{{timestamp: start, bytes: 0}}
+
+	getEvent := func(now time.Time, done bool) *ProgressEvent {
+		bytes := counter.Bytes()
+
+		// throughput is taken against the oldest snapshot within the window
+		for len(snapshots) > 1 && now.Sub(snapshots[1].timestamp) >= progressWindow {
+			snapshots = snapshots[1:]
+		}
+		oldest := snapshots[0]
+		snapshots = append(snapshots, &progressSnapshot{timestamp: now, bytes: bytes})
+
+		mbps := float64(0)
+		if elapsedUS := now.Sub(oldest.timestamp).Microseconds(); elapsedUS > 0 {
+			mbps = float64(8*(bytes-oldest.bytes)) / float64(elapsedUS)
+		}
+
+		return &ProgressEvent{
+			Phase:   phase,
+			Elapsed: now.Sub(start),
+			Bytes:   bytes,
+			MBPS:    mbps,
+			Done:    done,
+		}
+	}
+
+	stopped := make(chan struct{})
+	waitGroup := &sync.WaitGroup{}
+	waitGroup.Add(1)
+
+	go func() {
+		defer waitGroup.Done()
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		report(getEvent(start, false))
+		for {
+			select {
+			case now := <-ticker.C:
+				report(getEvent(now, false))
+			case <-stopped:
+				report(getEvent(time.Now(), true))
+				return
+			}
+		}
+	}()
+
+	stop := func() {
+		close(stopped)
+		waitGroup.Wait()
+	}
+
+	return WithProgressCounter(ctx, counter), stop
+}
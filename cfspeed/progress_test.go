@@ -0,0 +1,58 @@
+package cfspeed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestStartProgress(t *testing.T) {
+	events := []*ProgressEvent{}
+
+	ctx, stop := startProgress(context.Background(), PhaseDownlink, func(event *ProgressEvent) {
+		events = append(events, event)
+	})
+
+	counter := getProgressCounter(ctx)
+	assert.Assert(t, counter != nil)
+
+	counter.add(1000 * 1000)
+	time.Sleep(2*progressInterval + progressInterval/2)
+	stop()
+
+	assert.Assert(t, len(events) >= 3)
+	assert.Equal(t, events[0].Phase, PhaseDownlink)
+	assert.Equal(t, events[0].Elapsed, time.Duration(0))
+	for _, event := range events[:len(events)-1] {
+		assert.Assert(t, !event.Done)
+	}
+
+	lastEvent := events[len(events)-1]
+	assert.Assert(t, lastEvent.Done)
+	assert.Equal(t, lastEvent.Bytes, int64(1000*1000))
+	assert.Assert(t, lastEvent.MBPS > 0)
+}
+
+func TestStartProgress_Disabled(t *testing.T) {
+	ctx, stop := startProgress(context.Background(), PhaseDownlink, nil)
+	defer stop()
+
+	assert.Assert(t, getProgressCounter(ctx) == nil)
+}
+
+func TestProgressCounter_Measurements(t *testing.T) {
+	client := startDummyServer(t)
+
+	counter := &ProgressCounter{}
+	ctx := WithProgressCounter(context.Background(), counter)
+
+	dlMeasurement, err := client.doDownlinkMeasurement(ctx, 4*1024*1024, time.Now().Add(5*time.Second))
+	assert.NilError(t, err)
+	assert.Equal(t, counter.Bytes(), dlMeasurement.Size)
+
+	ulMeasurement, err := client.doUplinkMeasurement(ctx, 4*1024*1024, time.Now().Add(5*time.Second))
+	assert.NilError(t, err)
+	assert.Equal(t, counter.Bytes(), dlMeasurement.Size+ulMeasurement.Size)
+}
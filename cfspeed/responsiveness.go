@@ -0,0 +1,493 @@
+package cfspeed
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Parameters of responsiveness measurement, cf. https://datatracker.ietf.org/doc/draft-ietf-ippm-responsiveness/
+const (
+	rpmInterval              = 1 * time.Second                        // Interval at which saturation is assessed
+	rpmMovingAverageDistance = 4                                      // Number of intervals moving averages and their stability are taken over
+	rpmWindow                = rpmMovingAverageDistance * rpmInterval // Window of probes RPM is derived from, which is also the minimum duration
+	rpmStabilityTolerance    = 0.05                                   // Maximum standard deviation of moving averages relative to their mean for stability
+	rpmConnectionsAdded      = 1                                      // Load-generating connections added per direction at every interval until throughput saturates
+	rpmConnectionsMax        = 16                                     // Maximum number of load-generating connections per direction
+	rpmProbeInterval         = 100 * time.Millisecond                 // Interval between rounds of probes, each making a foreign probe and a self probe
+	rpmProbeTimeout          = 5 * time.Second                        // Timeout of each probe
+	rpmTrimmedPercentile     = 95                                     // Percentile beyond which probe results are discarded for trimmed means
+)
+
+// Confidence in the RPM reported, depending on whether throughput and responsiveness stabilised
+const (
+	ResponsivenessConfidenceHigh   = "high"   // Both throughput and responsiveness stabilised
+	ResponsivenessConfidenceMedium = "medium" // Either of throughput and responsiveness stabilised
+	ResponsivenessConfidenceLow    = "low"    // Neither stabilised within the maximum duration
+)
+
+// ResponsivenessStats are results of a responsiveness measurement; latencies are in ms and throughput in Mbps.
+// Latencies are of the probes made over the last intervals, from which RPM is derived.
+type ResponsivenessStats struct {
+	RPM                  float64 `json:"rpm"`                   // Round-trips per minute under working conditions
+	Confidence           string  `json:"confidence"`            // One of ResponsivenessConfidence*
+	ThroughputStable     bool    `json:"throughputStable"`      // Whether throughput saturated
+	ResponsivenessStable bool    `json:"responsivenessStable"`  // Whether responsiveness stabilised under the saturated load
+	Connections          int     `json:"connections"`           // Load-generating connections per direction at the end
+	DownlinkMBPS         float64 `json:"downlinkMbps"`          // Moving average of downlink throughput at the end
+	UplinkMBPS           float64 `json:"uplinkMbps"`            // Moving average of uplink throughput at the end
+	ForeignTCP           *Stats  `json:"foreignTCP,omitempty"`  // TCP handshakes of probes over new connections
+	ForeignTLS           *Stats  `json:"foreignTLS,omitempty"`  // TLS handshakes of probes over new connections; nil without TLS
+	ForeignHTTP          *Stats  `json:"foreignHTTP,omitempty"` // HTTP requests of probes over new connections
+	SelfHTTP             *Stats  `json:"selfHTTP,omitempty"`    // Probes over load-generating connections; nil unless they are multiplexed, e.g. over HTTP/2
+	Truncated            bool    `json:"truncated,omitempty"`   // Whether the load was cut short by the data budget`
+}
+
+type rpmProbe struct {
+	timestamp time.Time
+	self      bool
+	tcp       time.Duration
+	tls       time.Duration // Zero without TLS
+	http      time.Duration // From the request written to the first byte of the response
+}
+
+// rpmFlow is a load-generating connection, which has a transport of its own so as not to share connections with others
+type rpmFlow struct {
+	client    *Client
+	direction string
+	selfProbe atomic.Bool // Whether self probes can share the connection, which becomes false once they cannot
+}
+
+// newIsolatedClient makes a copy of the client that does not share connections with it
+func (c *Client) newIsolatedClient(disableKeepAlives bool) (*Client, error) {
+	transport, ok := c.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("responsiveness measurement needs the client to be based on http.Transport")
+	}
+
+	isolatedTransport := transport.Clone()
+	isolatedTransport.DisableKeepAlives = disableKeepAlives
+
+	isolated := *c
+	isolated.HTTPClient = &http.Client{
+		Transport: isolatedTransport,
+	}
+
+	return &isolated, nil
+}
+
+// generateLoad keeps transferring data until measureUntil, the context getting done or the data budget running out
+func (f *rpmFlow) generateLoad(ctx context.Context, measureUntil time.Time) {
+	defer f.client.CloseIdleConnections()
+
+	budget := getByteBudget(ctx)
+
+	for time.Until(measureUntil) > 0 && ctx.Err() == nil && !budget.Truncated() {
+		var err error = nil
+		if f.direction == DirectionDownlink {
+			_, err = f.client.doDownlinkMeasurement(ctx, f.client.Measurement.DownloadSizeMax, measureUntil)
+		} else {
+			_, err = f.client.doUplinkMeasurement(ctx, f.client.Measurement.UploadSizeMax, measureUntil)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// doRPMProbe requests an empty response, timing each component of the round trip.
+// The boolean returned tells whether an existing connection was reused.
+func (c *Client) doRPMProbe(ctx context.Context) (*rpmProbe, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, rpmProbeTimeout)
+	defer cancel()
+
+	ctx, tracer := withConnTracer(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.downURL(0), nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, _, err := flushHTTPResponse(resp, 0, time.Now(), nil); err != nil {
+		return nil, false, err
+	}
+
+	phases := tracer.getPhases()
+	probe := &rpmProbe{
+		timestamp: time.Now(),
+		tcp:       phases.Connect,
+		tls:       phases.TLS,
+		http:      phases.TTFB,
+	}
+
+	return probe, phases.Reused, nil
+}
+
+// getTrimmedMean takes the mean of samples up to the percentile given
+func getTrimmedMean(samples []float64, percentile int) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+
+	nKept := int(math.Ceil(float64(len(sorted)) * float64(percentile) / 100))
+	return getF64Mean(sorted[:nKept])
+}
+
+func getDurationsMS(durations []time.Duration) []float64 {
+	durationsMS := make([]float64, len(durations))
+	for index, duration := range durations {
+		durationsMS[index] = float64(duration.Nanoseconds()) / 1000 / 1000
+	}
+
+	return durationsMS
+}
+
+type rpmComponents struct {
+	foreignTCP  []time.Duration
+	foreignTLS  []time.Duration
+	foreignHTTP []time.Duration
+	selfHTTP    []time.Duration
+}
+
+func getRPMComponents(probes []*rpmProbe) *rpmComponents {
+	components := &rpmComponents{}
+
+	for _, probe := range probes {
+		if probe.self {
+			components.selfHTTP = append(components.selfHTTP, probe.http)
+			continue
+		}
+
+		components.foreignTCP = append(components.foreignTCP, probe.tcp)
+		if probe.tls > 0 {
+			components.foreignTLS = append(components.foreignTLS, probe.tls)
+		}
+		components.foreignHTTP = append(components.foreignHTTP, probe.http)
+	}
+
+	return components
+}
+
+// getRPM derives RPM from trimmed means of the components of probes, weighting foreign and self probes equally.
+// Foreign probes weigh their components equally, leaving TLS out when the server is not spoken to over TLS.
+// Either kind of probes alone determines RPM when the other is missing, e.g. self probes over HTTP/1.1.
+func (c *rpmComponents) getRPM() float64 {
+	foreignMSs := []float64{}
+	for _, component := range [][]time.Duration{c.foreignTCP, c.foreignTLS, c.foreignHTTP} {
+		if len(component) > 0 {
+			foreignMSs = append(foreignMSs, getTrimmedMean(getDurationsMS(component), rpmTrimmedPercentile))
+		}
+	}
+
+	latencyMSs := []float64{}
+	if len(foreignMSs) > 0 {
+		latencyMSs = append(latencyMSs, getF64Mean(foreignMSs))
+	}
+	if len(c.selfHTTP) > 0 {
+		latencyMSs = append(latencyMSs, getTrimmedMean(getDurationsMS(c.selfHTTP), rpmTrimmedPercentile))
+	}
+
+	latencyMS := getF64Mean(latencyMSs)
+	if latencyMS <= 0 {
+		return 0
+	}
+
+	return 60 * 1000 / latencyMS
+}
+
+// isStable tells whether the last moving averages deviate from their mean within the tolerance
+func isStable(movingAverages []float64) bool {
+	if len(movingAverages) < rpmMovingAverageDistance {
+		return false
+	}
+
+	last := movingAverages[len(movingAverages)-rpmMovingAverageDistance:]
+	mean := getF64Mean(last)
+
+	return mean > 0 && getF64StdDevUsingMean(last, mean) <= rpmStabilityTolerance*mean
+}
+
+func getMovingAverage(series []float64) float64 {
+	return getF64Mean(series[max(0, len(series)-rpmMovingAverageDistance):])
+}
+
+func getResponsivenessConfidence(throughputStable bool, responsivenessStable bool) string {
+	switch {
+	case throughputStable && responsivenessStable:
+		return ResponsivenessConfidenceHigh
+	case throughputStable || responsivenessStable:
+		return ResponsivenessConfidenceMedium
+	default:
+		return ResponsivenessConfidenceLow
+	}
+}
+
+// getResponsivenessConfidenceLevel ranks confidence from 0 for low to 2 for high, for sinks dealing with numbers only
+func getResponsivenessConfidenceLevel(confidence string) int {
+	switch confidence {
+	case ResponsivenessConfidenceHigh:
+		return 2
+	case ResponsivenessConfidenceMedium:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// rpmTest holds the state of a responsiveness measurement in progress
+type rpmTest struct {
+	client        *Client
+	foreignClient *Client
+	measureUntil  time.Time
+	waitGroup     *sync.WaitGroup
+
+	mutex  sync.Mutex
+	flows  []*rpmFlow
+	probes []*rpmProbe
+}
+
+func (t *rpmTest) addFlow(ctx context.Context, direction string) error {
+	client, err := t.client.newIsolatedClient(false)
+	if err != nil {
+		return err
+	}
+
+	flow := &rpmFlow{
+		client:    client,
+		direction: direction,
+	}
+	flow.selfProbe.Store(true)
+
+	t.mutex.Lock()
+	t.flows = append(t.flows, flow)
+	t.mutex.Unlock()
+
+	t.waitGroup.Add(1)
+	go func() {
+		defer t.waitGroup.Done()
+		flow.generateLoad(ctx, t.measureUntil)
+	}()
+
+	return nil
+}
+
+func (t *rpmTest) addProbe(probe *rpmProbe) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.probes = append(t.probes, probe)
+}
+
+// getProbesSince lists probes completed since the time given
+func (t *rpmTest) getProbesSince(since time.Time) []*rpmProbe {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	probes := []*rpmProbe{}
+	for _, probe := range t.probes {
+		if !probe.timestamp.Before(since) {
+			probes = append(probes, probe)
+		}
+	}
+
+	return probes
+}
+
+// startProbes makes a foreign probe and a self probe over a random load-generating connection every probe interval
+func (t *rpmTest) startProbes(ctx context.Context) {
+	t.waitGroup.Add(1)
+	go func() {
+		defer t.waitGroup.Done()
+
+		ticker := time.NewTicker(rpmProbeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			t.waitGroup.Add(1)
+			go func() {
+				defer t.waitGroup.Done()
+
+				if probe, _, err := t.foreignClient.doRPMProbe(ctx); err == nil {
+					t.addProbe(probe)
+				}
+			}()
+
+			t.mutex.Lock()
+			flow := t.flows[rand.IntN(len(t.flows))]
+			t.mutex.Unlock()
+			if !flow.selfProbe.Load() {
+				continue
+			}
+
+			t.waitGroup.Add(1)
+			go func() {
+				defer t.waitGroup.Done()
+
+				probe, reused, err := flow.client.doRPMProbe(ctx)
+				if err != nil {
+					return
+				}
+
+				// a new connection means that the loaded one cannot be shared, e.g. over HTTP/1.1
+				if !reused {
+					flow.selfProbe.Store(false)
+					return
+				}
+				probe.self = true
+				t.addProbe(probe)
+			}()
+		}
+	}()
+}
+
+// MeasureResponsiveness measures RPM under working conditions, i.e. with both directions loaded.
+// Load-generating connections are added until throughput saturates, while latency is probed over new and loaded connections.
+// The measurement ends once responsiveness stabilises under the saturated load, or after the maximum duration.
+func (c *Client) MeasureResponsiveness(ctx context.Context) (*ResponsivenessStats, error) {
+	foreignClient, err := c.newIsolatedClient(true)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	test := &rpmTest{
+		client:        c,
+		foreignClient: foreignClient,
+		measureUntil:  start.Add(c.Measurement.ResponsivenessDurationMax),
+		waitGroup:     &sync.WaitGroup{},
+	}
+
+	// throughput is counted by direction, passing bytes on to the progress counter if any
+	counters := map[string]*ProgressCounter{}
+	for _, direction := range []string{DirectionDownlink, DirectionUplink} {
+		counters[direction] = &ProgressCounter{parent: getProgressCounter(ctx)}
+	}
+
+	loadCtx, cancel := context.WithDeadline(ctx, test.measureUntil)
+	defer func() {
+		cancel()
+		test.waitGroup.Wait()
+	}()
+
+	nConnections := 0
+	addFlows := func() error {
+		for iter := 0; iter < rpmConnectionsAdded && nConnections < rpmConnectionsMax; iter += 1 {
+			for direction, counter := range counters {
+				if err := test.addFlow(WithProgressCounter(loadCtx, counter), direction); err != nil {
+					return err
+				}
+			}
+			nConnections += 1
+		}
+
+		return nil
+	}
+	if err := addFlows(); err != nil {
+		return nil, err
+	}
+	test.startProbes(loadCtx)
+
+	throughputs := map[string][]float64{}
+	totalThroughputs := []float64{}
+	throughputMovingAverages := []float64{}
+	rpmMovingAverages := []float64{}
+	lastBytes := map[string]int64{}
+	throughputStable, responsivenessStable := false, false
+
+	ticker := time.NewTicker(rpmInterval)
+	defer ticker.Stop()
+
+	for !(throughputStable && responsivenessStable) && !getByteBudget(ctx).Truncated() {
+		select {
+		case <-loadCtx.Done():
+		case <-ticker.C:
+		}
+		if loadCtx.Err() != nil {
+			break
+		}
+
+		totalMBPS := float64(0)
+		for direction, counter := range counters {
+			bytes := counter.Bytes()
+			mbps := float64(8*(bytes-lastBytes[direction])) / float64(rpmInterval.Microseconds())
+			lastBytes[direction] = bytes
+
+			throughputs[direction] = append(throughputs[direction], mbps)
+			totalMBPS += mbps
+		}
+		totalThroughputs = append(totalThroughputs, totalMBPS)
+		throughputMovingAverages = append(throughputMovingAverages, getMovingAverage(totalThroughputs))
+
+		if !throughputStable {
+			throughputStable = isStable(throughputMovingAverages)
+		}
+		if !throughputStable {
+			if err := addFlows(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// responsiveness is assessed under the saturated load only
+		rpmMovingAverages = append(rpmMovingAverages, getRPMComponents(test.getProbesSince(time.Now().Add(-rpmWindow))).getRPM())
+		responsivenessStable = isStable(rpmMovingAverages)
+	}
+
+	// cancellation from outside is a failure, whereas reaching the maximum duration is not
+	cancel()
+	test.waitGroup.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	components := getRPMComponents(test.getProbesSince(time.Now().Add(-rpmWindow)))
+	if len(components.foreignHTTP) == 0 && len(components.selfHTTP) == 0 {
+		return nil, fmt.Errorf("no responsiveness probes succeeded")
+	}
+
+	stats := &ResponsivenessStats{
+		RPM:                  components.getRPM(),
+		Confidence:           getResponsivenessConfidence(throughputStable, responsivenessStable),
+		ThroughputStable:     throughputStable,
+		ResponsivenessStable: responsivenessStable,
+		Connections:          nConnections,
+		DownlinkMBPS:         getMovingAverage(throughputs[DirectionDownlink]),
+		UplinkMBPS:           getMovingAverage(throughputs[DirectionUplink]),
+		Truncated:            getByteBudget(ctx).Truncated(),
+	}
+	for _, component := range []struct {
+		stats     **Stats
+		durations []time.Duration
+	}{
+		{&stats.ForeignTCP, components.foreignTCP},
+		{&stats.ForeignTLS, components.foreignTLS},
+		{&stats.ForeignHTTP, components.foreignHTTP},
+		{&stats.SelfHTTP, components.selfHTTP},
+	} {
+		if len(component.durations) > 0 {
+			*component.stats = getDurationMSStats(component.durations)
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,84 @@
+package cfspeed
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestGetTrimmedMean(t *testing.T) {
+	samples := []float64{}
+	for iter := 1; iter <= 20; iter += 1 {
+		samples = append(samples, float64(iter))
+	}
+	samples[0] = 1000
+
+	// 19 out of 20 are kept, discarding the outlier
+	assert.Assert(t, math.Abs(getTrimmedMean(samples, 95)-float64(2+20)/2) < 1e-9)
+	assert.Equal(t, getTrimmedMean([]float64{}, 95), float64(0))
+}
+
+func TestRPMComponents_GetRPM(t *testing.T) {
+	foreign := &rpmComponents{
+		foreignTCP:  []time.Duration{10 * time.Millisecond},
+		foreignTLS:  []time.Duration{20 * time.Millisecond},
+		foreignHTTP: []time.Duration{30 * time.Millisecond},
+	}
+	assert.Equal(t, foreign.getRPM(), float64(60*1000/20))
+
+	foreign.selfHTTP = []time.Duration{40 * time.Millisecond}
+	assert.Equal(t, foreign.getRPM(), float64(60*1000/30))
+
+	// TLS is left out over plain HTTP
+	assert.Equal(t, (&rpmComponents{
+		foreignTCP:  []time.Duration{10 * time.Millisecond},
+		foreignHTTP: []time.Duration{30 * time.Millisecond},
+	}).getRPM(), float64(60*1000/20))
+
+	assert.Equal(t, (&rpmComponents{}).getRPM(), float64(0))
+}
+
+func TestIsStable(t *testing.T) {
+	assert.Assert(t, !isStable([]float64{100, 100, 100}))
+	assert.Assert(t, isStable([]float64{10, 100, 101, 99, 100}))
+	assert.Assert(t, !isStable([]float64{100, 80, 120, 100}))
+	assert.Assert(t, !isStable([]float64{0, 0, 0, 0}))
+}
+
+func TestGetResponsivenessConfidence(t *testing.T) {
+	assert.Equal(t, getResponsivenessConfidence(true, true), ResponsivenessConfidenceHigh)
+	assert.Equal(t, getResponsivenessConfidence(true, false), ResponsivenessConfidenceMedium)
+	assert.Equal(t, getResponsivenessConfidence(false, false), ResponsivenessConfidenceLow)
+}
+
+func TestMeasureResponsiveness(t *testing.T) {
+	client := startDummyServer(t)
+	client.Measurement.ResponsivenessDurationMax = rpmWindow
+
+	start := time.Now()
+	stats, err := client.MeasureResponsiveness(context.Background())
+	assert.NilError(t, err)
+	assert.Assert(t, time.Since(start) < rpmWindow+rpmProbeTimeout)
+
+	assert.Assert(t, stats.RPM > 0)
+	assert.Assert(t, stats.Connections >= 1)
+	assert.Assert(t, stats.DownlinkMBPS > 0 && stats.UplinkMBPS > 0)
+	assert.Assert(t, stats.ForeignTCP != nil && stats.ForeignHTTP != nil)
+
+	// the dummy server speaks neither TLS nor HTTP/2
+	assert.Assert(t, stats.ForeignTLS == nil && stats.SelfHTTP == nil)
+}
+
+func TestMeasureResponsiveness_Cancellation(t *testing.T) {
+	client := startDummyServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	stats, err := client.MeasureResponsiveness(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Assert(t, stats == nil)
+}
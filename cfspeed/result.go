@@ -0,0 +1,44 @@
+package cfspeed
+
+import (
+	"time"
+)
+
+// ResultSchemaVersion is bumped whenever the structure of Report changes in an incompatible way
+const ResultSchemaVersion = 1
+
+type RunResult struct {
+	Timestamp         time.Time              `json:"timestamp"`
+	TransportProtocol string                 `json:"transportProtocol"`
+	Metadata          *MeasurementMetadata   `json:"metadata,omitempty"`
+	UnloadedRTT       *Stats                 `json:"unloadedRTT,omitempty"`
+	Downlink          *SpeedMeasurementStats `json:"downlink,omitempty"`
+	DownlinkLoadedRTT *Stats                 `json:"downlinkLoadedRTT,omitempty"`
+	Uplink            *SpeedMeasurementStats `json:"uplink,omitempty"`
+	UplinkLoadedRTT   *Stats                 `json:"uplinkLoadedRTT,omitempty"`
+
+	// Measurements made with downlink and uplink loaded simultaneously
+	BidirectionalDownlink  *SpeedMeasurementStats `json:"bidirectionalDownlink,omitempty"`
+	BidirectionalUplink    *SpeedMeasurementStats `json:"bidirectionalUplink,omitempty"`
+	BidirectionalLoadedRTT *Stats                 `json:"bidirectionalLoadedRTT,omitempty"`
+
+	Responsiveness *ResponsivenessStats `json:"responsiveness,omitempty"`
+
+	Scores *Scores           `json:"scores,omitempty"`
+	Checks []*ThresholdCheck `json:"checks,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"` // Failures of measurements left out without failing the run, e.g. of loaded RTT
+	Error    string   `json:"error,omitempty"`
+}
+
+type Report struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Runs          []*RunResult `json:"runs"`
+}
+
+func NewReport() *Report {
+	return &Report{
+		SchemaVersion: ResultSchemaVersion,
+		Runs:          []*RunResult{},
+	}
+}
@@ -2,279 +2,337 @@ package cfspeed
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"net"
-	"net/http"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
 const (
-	defaultDialTimeout = 10 * time.Second
-	defaultRunTimeout  = 30 * time.Second
+	loadedRTTMeasurementDelay = 1000 * time.Millisecond
 )
 
-func printMetadata(printer *log.Logger, metadata *MeasurementMetadata) {
-	if metadata != nil {
-		printer.Printf("SrcIP: %s (AS%s)\n", metadata.SrcIP, metadata.SrcASN)
-		printer.Printf("SrcLocation: %s, %s\n", metadata.SrcCity, metadata.SrcCountry)
-		printer.Printf("DstColocation: %s\n", metadata.DstColo)
-	}
+// RunOptions determines the measurements to be made by Run
+type RunOptions struct {
+	Multiplicity          int              // Number of connections in parallel for speed measurements
+	MeasureRTT            bool             // Whether to measure unloaded and loaded RTT
+	SkipDownlink          bool             // Whether to leave out the downlink measurement
+	SkipUplink            bool             // Whether to leave out the uplink measurement
+	MeasureBidirectional  bool             // Whether to measure downlink and uplink simultaneously after the measurements above
+	MeasureResponsiveness bool             // Whether to measure responsiveness in RPM at the end
+	Thresholds            *Thresholds      // Levels to be checked after successful runs; nil for none
+	ScoreThresholds       *ScoreThresholds // Levels the run is graded and rated by; nil for no scores
+
+	KeepMeasurements bool         // Whether to retain raw measurements in the result, e.g. for recording traces
+	Progress         ProgressFunc // Receiver of progress of the run; nil for none
 }
 
-func formatDeciles(deciles []float64) string {
-	numStrs := []string{}
+// getNSpeedPhases counts the speed measurement phases of a run, among which the data budget is shared
+func (o *RunOptions) getNSpeedPhases() int {
+	nSpeedPhases := 0
 
-	for _, decile := range deciles {
-		numStrs = append(numStrs, fmt.Sprintf("%.3f", decile))
+	for _, enabled := range []bool{!o.SkipDownlink, !o.SkipUplink, o.MeasureBidirectional, o.MeasureResponsiveness} {
+		if enabled {
+			nSpeedPhases += 1
+		}
 	}
 
-	return fmt.Sprintf("%v", numStrs)
+	return nSpeedPhases
 }
 
-func printRTTMeasurement(printer *log.Logger, label string, measurement *Stats) {
-	if measurement != nil {
-		printer.Printf("%s-mean: %.3f ms\n", label, measurement.Mean)
-		printer.Printf("%s-stderr: %.3f ms\n", label, measurement.StdErr)
-		printer.Printf("%s-min: %.3f ms\n", label, measurement.Min)
-		printer.Printf("%s-max: %.3f ms\n", label, measurement.Max)
-		printer.Printf("%s-deciles: %s ms\n", label, formatDeciles(measurement.Deciles))
-		printer.Printf("%s-n: %d\n", label, measurement.NSamples)
-	}
-}
+func runMeasurementMetadata(ctx context.Context, client *Client) (*MeasurementMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.Measurement.RunTimeout)
+	defer cancel()
 
-func printSpeedMeasurement(printer *log.Logger, label string, measurement *SpeedMeasurementStats) {
-	if measurement != nil {
-		printer.Printf("%s-mean: %.3f Mbps\n", label, measurement.Mean)
-		printer.Printf("%s-stderr: %.3f Mbps\n", label, measurement.StdErr)
-		printer.Printf("%s-min: %.3f Mbps\n", label, measurement.Min)
-		printer.Printf("%s-max: %.3f Mbps\n", label, measurement.Max)
-		printer.Printf("%s-deciles: %s Mbps\n", label, formatDeciles(measurement.Deciles))
-		printer.Printf("%s-cat: %.3f Mbps\n", label, measurement.CatSpeed)
-		printer.Printf("%s-tx: %.3f MiB\n", label, float64(measurement.TXSize)/1024/1024)
-		printer.Printf("%s-mx: %d\n", label, measurement.Multiplicity)
-		printer.Printf("%s-n: %d\n", label, measurement.NSamples)
+	measurementMetadata, err := client.GetMeasurementMetadata(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch metadata")
 	}
+
+	return measurementMetadata, nil
 }
 
-func runAndPrintMeasurementMetadata(printer *log.Logger) error {
-	measurementMetadata, err := GetMeasurementMetadata()
+func runUnloadedRTTMeasurement(ctx context.Context, client *Client) (*Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.Measurement.RunTimeout)
+	defer cancel()
 
+	rttStats, _, err := client.MeasureRTT(ctx)
 	if err != nil {
-		return errors.Wrap(err, "could not fetch metadata")
+		return nil, errors.Wrap(err, "RTT measurement failed")
 	}
 
-	printMetadata(printer, measurementMetadata)
+	return rttStats, nil
+}
 
-	return nil
+type loadedRTTResult struct {
+	stats *Stats
+	err   error
 }
 
-func runAndPrintMeasurementMetadataWithTimeout(printer *log.Logger, timeout time.Duration) error {
-	var err error = nil
-	completed := make(chan bool)
+// getLoadedRTTWindow tells the delay of loaded RTT measurements from the start of speed measurements and their maximum duration.
+// Both are scaled down for short speed measurements, leaving as much margin at the end as the delay, so that RTT is measured while the load continues.
+func (o *MeasurementOptions) getLoadedRTTWindow() (time.Duration, time.Duration) {
+	delay := min(loadedRTTMeasurementDelay, o.SpeedDuration/4)
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	return delay, min(o.RTTDurationMax, o.SpeedDuration-2*delay)
+}
+
+// startLoadedRTTMeasurement measures RTT shortly after a speed measurement has started.
+// The channel returned yields the error if the measurement fails or gets cancelled.
+func startLoadedRTTMeasurement(ctx context.Context, client *Client) <-chan *loadedRTTResult {
+	loadedRTTDone := make(chan *loadedRTTResult, 1)
+	delay, durationMax := client.Measurement.getLoadedRTTWindow()
 
 	go func() {
-		err = runAndPrintMeasurementMetadata(printer)
-		completed <- true
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			loadedRTTDone <- &loadedRTTResult{err: ctx.Err()}
+			return
+		}
+
+		loadedRTTStats, _, err := client.measureRTT(ctx, durationMax)
+		loadedRTTDone <- &loadedRTTResult{stats: loadedRTTStats, err: err}
 	}()
 
-	select {
-	case <-completed:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	return loadedRTTDone
 }
 
-func runAndPrintUnloadedRTTMeasurement(printer *log.Logger) error {
-	rttStats, _, err := MeasureRTT()
+// runLoadedMeasurement runs speed measurements with loadFunc, measuring RTT under the load if requested.
+// Failure of the loaded RTT measurement leaves the speed measurements intact; it is returned for the caller to record.
+func runLoadedMeasurement(ctx context.Context, client *Client, loadFunc func(context.Context) ([]*SpeedMeasurementStats, error), budget *ByteBudget, opts *RunOptions) ([]*SpeedMeasurementStats, *loadedRTTResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.Measurement.RunTimeout)
+	defer cancel()
 
-	if err != nil {
-		return errors.Wrap(err, "RTT measurement failed")
+	if budget != nil {
+		ctx = WithByteBudget(ctx, budget)
 	}
 
-	printRTTMeasurement(printer, "RTT-Unloaded", rttStats)
-
-	return nil
-}
-
-func runAndPrintUnloadedRTTMeasurementWithTimeout(printer *log.Logger, timeout time.Duration) error {
-	var err error = nil
-	completed := make(chan bool)
-
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	var loadedRTTDone <-chan *loadedRTTResult = nil
+	if opts.MeasureRTT {
+		loadedRTTDone = startLoadedRTTMeasurement(ctx, client)
+	}
 
-	go func() {
-		err = runAndPrintUnloadedRTTMeasurement(printer)
-		completed <- true
-	}()
+	speedStatsList, err := loadFunc(ctx)
+	if err != nil {
+		if loadedRTTDone != nil {
+			cancel()
+			<-loadedRTTDone
+		}
+		return nil, nil, err
+	}
+	for _, speedStats := range speedStatsList {
+		if !opts.KeepMeasurements {
+			speedStats.Measurements = nil
+		}
+		speedStats.Truncated = budget.Truncated()
+	}
 
-	select {
-	case <-completed:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
+	if loadedRTTDone != nil {
+		return speedStatsList, <-loadedRTTDone, nil
 	}
+
+	return speedStatsList, nil, nil
 }
 
-func runAndPrintDownlinkMeasurement(printer *log.Logger, multiplicity int, measureLoadedRTT bool) error {
-	var dlStats *SpeedMeasurementStats
-	var dlLoadedRTTStats *Stats
-	var dlSpeedError error
-	var dlLoadedRTTErr error
+func runSpeedMeasurement(ctx context.Context, client *Client, measureFunc func(context.Context, int) (*SpeedMeasurementStats, error), budget *ByteBudget, opts *RunOptions) (*SpeedMeasurementStats, *loadedRTTResult, error) {
+	speedStatsList, loadedRTT, err := runLoadedMeasurement(ctx, client, func(ctx context.Context) ([]*SpeedMeasurementStats, error) {
+		speedStats, err := measureFunc(ctx, opts.Multiplicity)
+		return []*SpeedMeasurementStats{speedStats}, err
+	}, budget, opts)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	dlLoadedRTTDone := make(chan bool)
+	return speedStatsList[0], loadedRTT, nil
+}
 
-	if measureLoadedRTT {
-		go func() {
-			time.Sleep(1000 * time.Millisecond)
-			dlLoadedRTTStats, _, dlLoadedRTTErr = MeasureRTT()
-			dlLoadedRTTDone <- true
-		}()
+func runDownlinkMeasurement(ctx context.Context, client *Client, budget *ByteBudget, opts *RunOptions) (*SpeedMeasurementStats, *loadedRTTResult, error) {
+	dlStats, dlLoadedRTT, err := runSpeedMeasurement(ctx, client, client.MeasureDownlink, budget, opts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "downlink measurement failed")
 	}
 
-	if multiplicity > 0 {
-		dlStats, dlSpeedError = MeasureDownlinkMultiplexed(multiplicity)
-	} else {
-		dlStats, dlSpeedError = MeasureDownlink()
-	}
-	if dlSpeedError != nil {
-		return errors.Wrap(dlSpeedError, "downlink measurement failed")
+	return dlStats, dlLoadedRTT, nil
+}
+
+func runUplinkMeasurement(ctx context.Context, client *Client, budget *ByteBudget, opts *RunOptions) (*SpeedMeasurementStats, *loadedRTTResult, error) {
+	ulStats, ulLoadedRTT, err := runSpeedMeasurement(ctx, client, client.MeasureUplink, budget, opts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "uplink measurement failed")
 	}
 
-	printSpeedMeasurement(printer, "Downlink", dlStats)
+	return ulStats, ulLoadedRTT, nil
+}
 
-	if measureLoadedRTT && <-dlLoadedRTTDone && dlLoadedRTTErr == nil {
-		printer.Println()
-		printRTTMeasurement(printer, "RTT-DownlinkLoaded", dlLoadedRTTStats)
+func runBidirectionalMeasurement(ctx context.Context, client *Client, budget *ByteBudget, opts *RunOptions) (*SpeedMeasurementStats, *SpeedMeasurementStats, *loadedRTTResult, error) {
+	speedStatsList, loadedRTT, err := runLoadedMeasurement(ctx, client, func(ctx context.Context) ([]*SpeedMeasurementStats, error) {
+		dlStats, ulStats, err := client.MeasureBidirectional(ctx, opts.Multiplicity)
+		return []*SpeedMeasurementStats{dlStats, ulStats}, err
+	}, budget, opts)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "bidirectional measurement failed")
 	}
 
-	return nil
+	return speedStatsList[0], speedStatsList[1], loadedRTT, nil
 }
 
-func runAndPrintDownlinkMeasurementWithTimeout(printer *log.Logger, multiplicity int, measureLoadedRTT bool, timeout time.Duration) error {
-	var err error = nil
-	completed := make(chan bool)
-
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// runResponsivenessMeasurement measures responsiveness, which ends by itself within its maximum duration and is bounded by the run timeout as other phases are
+func runResponsivenessMeasurement(ctx context.Context, client *Client, budget *ByteBudget) (*ResponsivenessStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.Measurement.RunTimeout)
 	defer cancel()
 
-	go func() {
-		err = runAndPrintDownlinkMeasurement(printer, multiplicity, measureLoadedRTT)
-		completed <- true
-	}()
+	if budget != nil {
+		ctx = WithByteBudget(ctx, budget)
+	}
 
-	select {
-	case <-completed:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
+	responsivenessStats, err := client.MeasureResponsiveness(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "responsiveness measurement failed")
 	}
-}
 
-func runAndPrintUplinkMeasurement(printer *log.Logger, multiplicity int, measureLoadedRTT bool) error {
-	var ulStats *SpeedMeasurementStats
-	var ulLoadedRTTStats *Stats
-	var ulSpeedError error
-	var ulLoadedRTTErr error
+	return responsivenessStats, nil
+}
 
-	ulLoadedRTTDone := make(chan bool)
+// runPhase runs a phase of a run, reporting its progress if requested
+func runPhase(ctx context.Context, phase string, opts *RunOptions, phaseFunc func(context.Context) error) error {
+	ctx, stopProgress := startProgress(ctx, phase, opts.Progress)
+	defer stopProgress()
 
-	if measureLoadedRTT {
-		go func() {
-			time.Sleep(1000 * time.Millisecond)
-			ulLoadedRTTStats, _, ulLoadedRTTErr = MeasureRTT()
-			ulLoadedRTTDone <- true
-		}()
-	}
+	return phaseFunc(ctx)
+}
 
-	if multiplicity > 0 {
-		ulStats, ulSpeedError = MeasureUplinkMultiplexed(multiplicity)
-	} else {
-		ulStats, ulSpeedError = MeasureUplink()
+// takeLoadedRTT tells the stats of a loaded RTT measurement, if any, recording its failure on the result as a warning
+func (r *RunResult) takeLoadedRTT(phase string, loadedRTT *loadedRTTResult) *Stats {
+	if loadedRTT == nil {
+		return nil
 	}
-	if ulSpeedError != nil {
-		return errors.Wrap(ulSpeedError, "uplink measurement failed")
+	if loadedRTT.err != nil {
+		r.Warnings = append(r.Warnings, errors.Wrapf(loadedRTT.err, "%s loaded RTT measurement failed", phase).Error())
+		return nil
 	}
 
-	printSpeedMeasurement(printer, "Uplink", ulStats)
+	return loadedRTT.stats
+}
 
-	if measureLoadedRTT && <-ulLoadedRTTDone && ulLoadedRTTErr == nil {
-		printer.Println()
-		printRTTMeasurement(printer, "RTT-UplinkLoaded", ulLoadedRTTStats)
+// assessRun checks the result of a successful run against the thresholds and scores it, as opts tells
+func assessRun(result *RunResult, opts *RunOptions) {
+	if opts.Thresholds != nil {
+		result.Checks = opts.Thresholds.evaluate(result, !opts.SkipDownlink, !opts.SkipUplink)
+	}
+	if opts.ScoreThresholds != nil {
+		result.Scores = opts.ScoreThresholds.Score(result)
 	}
-
-	return nil
 }
 
-func runAndPrintUplinkMeasurementWithTimeout(printer *log.Logger, multiplicity int, measureLoadedRTT bool, timeout time.Duration) error {
+// Run carries out the sequence of measurements with the client given, leaving out phases as opts tells.
+// Every phase is bounded by its own timeout in addition to ctx, and no goroutine is left running on return.
+// On failure, the result holds the phases completed before the error.
+func Run(ctx context.Context, client *Client, opts *RunOptions) (*RunResult, error) {
 	var err error = nil
-	completed := make(chan bool)
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	go func() {
-		err = runAndPrintUplinkMeasurement(printer, multiplicity, measureLoadedRTT)
-		completed <- true
-	}()
+	result := &RunResult{
+		Timestamp:         time.Now(),
+		TransportProtocol: client.Network,
+	}
 
-	select {
-	case <-completed:
+	err = runPhase(ctx, PhaseMetadata, opts, func(ctx context.Context) (err error) {
+		result.Metadata, err = runMeasurementMetadata(ctx, client)
 		return err
-	case <-ctx.Done():
-		return ctx.Err()
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if opts.MeasureRTT {
+		err = runPhase(ctx, PhaseRTT, opts, func(ctx context.Context) (err error) {
+			result.UnloadedRTT, err = runUnloadedRTTMeasurement(ctx, client)
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
 	}
-}
 
-func SetTransportProtocol(protocol string, dialTimeout time.Duration) {
-	// cf. https://go.googlesource.com/go/+/refs/tags/go1.22.1/src/net/http/transport.go#43
-	// cf. https://go.googlesource.com/go/+/refs/tags/go1.22.1/src/net/http/transport.go#140
-	http.DefaultTransport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
-			return (&net.Dialer{
-				Timeout:   dialTimeout,
-				KeepAlive: 30 * time.Second,
-			}).DialContext(ctx, protocol, addr)
-		},
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
+	// every speed phase may use up to an even share of the data budget left, e.g. downlink up to a half and uplink the rest
+	nSpeedPhasesLeft := opts.getNSpeedPhases()
+	var bytesUsed int64 = 0
+	getBudget := func() *ByteBudget {
+		if client.Measurement.BytesMax <= 0 {
+			return nil
+		}
+
+		budget := newPhaseByteBudget((client.Measurement.BytesMax-bytesUsed)/int64(nSpeedPhasesLeft), opts.Multiplicity)
+		nSpeedPhasesLeft -= 1
+		return budget
 	}
-}
 
-func RunAndPrint(printer *log.Logger, transportProtocol string, multiplicity int, measureRTT bool) error {
-	SetTransportProtocol(transportProtocol, defaultDialTimeout)
+	if !opts.SkipDownlink {
+		budget := getBudget()
+		err = runPhase(ctx, PhaseDownlink, opts, func(ctx context.Context) (err error) {
+			var loadedRTT *loadedRTTResult = nil
+			result.Downlink, loadedRTT, err = runDownlinkMeasurement(ctx, client, budget, opts)
+			result.DownlinkLoadedRTT = result.takeLoadedRTT(PhaseDownlink, loadedRTT)
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+		bytesUsed += budget.Used()
+	}
 
-	if err := runAndPrintMeasurementMetadataWithTimeout(printer, defaultRunTimeout); err != nil {
-		return err
+	if !opts.SkipUplink {
+		budget := getBudget()
+		err = runPhase(ctx, PhaseUplink, opts, func(ctx context.Context) (err error) {
+			var loadedRTT *loadedRTTResult = nil
+			result.Uplink, loadedRTT, err = runUplinkMeasurement(ctx, client, budget, opts)
+			result.UplinkLoadedRTT = result.takeLoadedRTT(PhaseUplink, loadedRTT)
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+		bytesUsed += budget.Used()
 	}
-	printer.Println()
 
-	if measureRTT {
-		if err := runAndPrintUnloadedRTTMeasurementWithTimeout(printer, defaultRunTimeout); err != nil {
+	if opts.MeasureBidirectional {
+		budget := getBudget()
+		err = runPhase(ctx, PhaseBidirectional, opts, func(ctx context.Context) (err error) {
+			var loadedRTT *loadedRTTResult = nil
+			result.BidirectionalDownlink, result.BidirectionalUplink, loadedRTT, err = runBidirectionalMeasurement(ctx, client, budget, opts)
+			result.BidirectionalLoadedRTT = result.takeLoadedRTT(PhaseBidirectional, loadedRTT)
 			return err
+		})
+		if err != nil {
+			return result, err
 		}
-		printer.Println()
+		bytesUsed += budget.Used()
 	}
 
-	if err := runAndPrintDownlinkMeasurementWithTimeout(printer, multiplicity, measureRTT, defaultRunTimeout); err != nil {
-		return err
+	if opts.MeasureResponsiveness {
+		budget := getBudget()
+		err = runPhase(ctx, PhaseResponsiveness, opts, func(ctx context.Context) (err error) {
+			result.Responsiveness, err = runResponsivenessMeasurement(ctx, client, budget)
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
 	}
-	printer.Println()
 
-	if err := runAndPrintUplinkMeasurementWithTimeout(printer, multiplicity, measureRTT, defaultRunTimeout); err != nil {
-		return err
+	assessRun(result, opts)
+
+	return result, nil
+}
+
+func RunAndPrint(ctx context.Context, resultWriter ResultWriter, client *Client, opts *RunOptions) (*RunResult, error) {
+	result, err := Run(ctx, client, opts)
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if writeErr := resultWriter.WriteRun(result); writeErr != nil && err == nil {
+		err = writeErr
 	}
 
-	return nil
+	return result, err
 }
@@ -0,0 +1,130 @@
+package cfspeed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestRun_RTTOnly(t *testing.T) {
+	client := startDummyServer(t)
+
+	result, err := Run(context.Background(), client, &RunOptions{
+		Multiplicity: 1,
+		MeasureRTT:   true,
+		SkipDownlink: true,
+		SkipUplink:   true,
+		Thresholds:   &Thresholds{MinDownlink: 100, MaxRTT: 1000},
+	})
+	assert.NilError(t, err)
+
+	assert.Equal(t, result.Metadata.DstColo, "NRT")
+	assert.Assert(t, result.UnloadedRTT != nil)
+	assert.Assert(t, result.Downlink == nil && result.Uplink == nil)
+	assert.Equal(t, len(result.Checks), 1)
+	assert.Assert(t, result.ChecksPassed())
+}
+
+func TestRun_UplinkOnly(t *testing.T) {
+	client := startDummyServer(t)
+	client.Measurement.SpeedDuration = 500 * time.Millisecond
+	client.Measurement.BytesMax = 64 * 1024 * 1024
+
+	result, err := Run(context.Background(), client, &RunOptions{
+		Multiplicity: 1,
+		SkipDownlink: true,
+	})
+	assert.NilError(t, err)
+
+	assert.Assert(t, result.UnloadedRTT == nil && result.Downlink == nil)
+	assert.Assert(t, result.Uplink != nil)
+	assert.Assert(t, result.Uplink.TXSize > 0)
+}
+
+func TestRun_Bidirectional(t *testing.T) {
+	client := startDummyServer(t)
+	client.Measurement.SpeedDuration = 500 * time.Millisecond
+	client.Measurement.BytesMax = 96 * 1024 * 1024
+
+	result, err := Run(context.Background(), client, &RunOptions{
+		Multiplicity:         2,
+		MeasureRTT:           true,
+		MeasureBidirectional: true,
+	})
+	assert.NilError(t, err)
+
+	assert.Assert(t, result.Downlink != nil && result.Uplink != nil)
+	assert.Assert(t, result.BidirectionalDownlink != nil && result.BidirectionalUplink != nil)
+	assert.Assert(t, result.BidirectionalDownlink.TXSize > 0 && result.BidirectionalUplink.TXSize > 0)
+	assert.Equal(t, result.BidirectionalUplink.Multiplicity, 2)
+
+	totalSize := result.Downlink.TXSize + result.Uplink.TXSize + result.BidirectionalDownlink.TXSize + result.BidirectionalUplink.TXSize
+	assert.Assert(t, totalSize <= client.Measurement.BytesMax)
+}
+
+func TestRun_LoadedRTTFailure(t *testing.T) {
+	var downlinkStarted atomic.Bool
+	speedServer := NewServer(&ServerOptions{DstColo: "NRT"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/__down" && r.URL.Query().Get("bytes") != "0":
+			downlinkStarted.Store(true)
+		// RTT is measured with empty uploads, which fail once the downlink is loaded
+		case r.URL.Path == "/__up" && downlinkStarted.Load():
+			panic(http.ErrAbortHandler)
+		}
+		speedServer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	config := NewConfig()
+	config.BaseURL = server.URL
+	config.Measurement.SpeedDuration = 2 * time.Second
+	config.Measurement.BytesMax = 64 * 1024 * 1024
+	client, err := NewClient(config)
+	assert.NilError(t, err)
+	t.Cleanup(client.CloseIdleConnections)
+
+	result, err := Run(context.Background(), client, &RunOptions{
+		Multiplicity: 1,
+		MeasureRTT:   true,
+		SkipUplink:   true,
+		Thresholds:   &Thresholds{MaxLoadedRTT: 1000},
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, result.UnloadedRTT != nil && result.Downlink != nil && result.DownlinkLoadedRTT == nil)
+	assert.Equal(t, len(result.Warnings), 1)
+	assert.Assert(t, strings.HasPrefix(result.Warnings[0], "downlink loaded RTT measurement failed"))
+
+	// the check is left unmeasured rather than failed
+	assert.Equal(t, len(result.Checks), 1)
+	assert.Assert(t, !result.Checks[0].Measured)
+	assert.Assert(t, result.ChecksPassed())
+}
+
+func TestMeasurementOptions_GetLoadedRTTWindow(t *testing.T) {
+	for _, testCase := range []struct {
+		speedDuration time.Duration
+		delay         time.Duration
+		durationMax   time.Duration
+	}{
+		{10 * time.Second, time.Second, 2 * time.Second},
+		{3 * time.Second, 750 * time.Millisecond, 1500 * time.Millisecond},
+		{time.Second, 250 * time.Millisecond, 500 * time.Millisecond},
+	} {
+		opts := NewMeasurementOptions()
+		opts.SpeedDuration = testCase.speedDuration
+
+		delay, durationMax := opts.getLoadedRTTWindow()
+		assert.Equal(t, delay, testCase.delay)
+		assert.Equal(t, durationMax, testCase.durationMax)
+		// RTT is measured within the load
+		assert.Assert(t, delay+durationMax < testCase.speedDuration)
+	}
+}
@@ -0,0 +1,246 @@
+package cfspeed
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Use cases rated, after the Aggregated Internet Measurement (AIM) of Cloudflare's Speed Test
+const (
+	UseCaseStreaming         = "streaming"
+	UseCaseGaming            = "gaming"
+	UseCaseVideoConferencing = "video-conferencing"
+)
+
+var useCases = []string{UseCaseStreaming, UseCaseGaming, UseCaseVideoConferencing}
+
+// Metrics use cases are rated by
+const (
+	ScoreMetricDownlink  = "down"       // Mean of downlink speed in Mbps; higher is better
+	ScoreMetricUplink    = "up"         // Mean of uplink speed in Mbps; higher is better
+	ScoreMetricRTT       = "rtt"        // Mean of unloaded RTT in ms
+	ScoreMetricLoadedRTT = "loaded-rtt" // Mean of RTT in ms under the heaviest of the loads measured
+	ScoreMetricJitter    = "jitter"     // Jitter of unloaded RTT in ms
+)
+
+// Ratings of use cases from the best to the worst
+const (
+	RatingGreat   = "great"
+	RatingGood    = "good"
+	RatingAverage = "average"
+	RatingPoor    = "poor"
+	RatingBad     = "bad"
+)
+
+var ratings = []string{RatingGreat, RatingGood, RatingAverage, RatingPoor, RatingBad}
+
+// Bufferbloat grades from the best to the worst
+var bufferbloatGrades = []string{"A+", "A", "B", "C", "D", "F"}
+
+// ScoreThresholds determine the bufferbloat grade and ratings of use cases.
+// Bounds are given from the best level to the worst, and values beyond the last bound get the worst.
+type ScoreThresholds struct {
+	// Upper bounds of latency increase under load in ms for grades A+, A, B, C and D
+	Bufferbloat []float64
+
+	// Bounds of metrics for ratings great, good, average and poor by use case and metric.
+	// They are lower bounds of speed and upper bounds of latency. A use case gets the worst rating of its metrics measured.
+	UseCases map[string]map[string][]float64
+}
+
+// NewScoreThresholds makes thresholds of the default levels
+func NewScoreThresholds() *ScoreThresholds {
+	return &ScoreThresholds{
+		// latency increase hardly noticeable up to 5 ms, and disruptive to real-time applications beyond 200 ms
+		Bufferbloat: []float64{5, 30, 60, 200, 400},
+
+		UseCases: map[string]map[string][]float64{
+			// 4K streams need 25 Mbps and HD ones 5 Mbps; buffering absorbs latency to a large extent
+			UseCaseStreaming: {
+				ScoreMetricDownlink:  {25, 15, 5, 3},
+				ScoreMetricLoadedRTT: {100, 200, 500, 1000},
+			},
+			// games are sensitive to latency and its variation rather than speed
+			UseCaseGaming: {
+				ScoreMetricRTT:       {20, 40, 60, 100},
+				ScoreMetricLoadedRTT: {50, 100, 150, 300},
+				ScoreMetricJitter:    {5, 10, 20, 40},
+			},
+			// HD group calls need a few Mbps in both directions and conversations suffer beyond 150 ms
+			UseCaseVideoConferencing: {
+				ScoreMetricDownlink:  {10, 4, 2, 1},
+				ScoreMetricUplink:    {8, 4, 2, 1},
+				ScoreMetricRTT:       {50, 100, 150, 300},
+				ScoreMetricLoadedRTT: {100, 200, 300, 500},
+				ScoreMetricJitter:    {10, 20, 30, 50},
+			},
+		},
+	}
+}
+
+func isScoreMetricHigherBetter(metric string) bool {
+	return metric == ScoreMetricDownlink || metric == ScoreMetricUplink
+}
+
+// validateBounds tells whether bounds are ordered from the best level to the worst
+func validateBounds(name string, bounds []float64, nBounds int, higherBetter bool) error {
+	if len(bounds) != nBounds {
+		return fmt.Errorf(`invalid bounds of "%s"; %d of them are needed`, name, nBounds)
+	}
+
+	for index, bound := range bounds {
+		if bound < 0 {
+			return fmt.Errorf(`invalid bounds of "%s"; they need to be non-negative`, name)
+		}
+		if index > 0 && ((higherBetter && bound > bounds[index-1]) || (!higherBetter && bound < bounds[index-1])) {
+			return fmt.Errorf(`invalid bounds of "%s"; they need to be ordered from the best level to the worst`, name)
+		}
+	}
+
+	return nil
+}
+
+func (t *ScoreThresholds) Validate() error {
+	if err := validateBounds("bufferbloat", t.Bufferbloat, len(bufferbloatGrades)-1, false); err != nil {
+		return err
+	}
+
+	for useCase, metrics := range t.UseCases {
+		if !slices.Contains(useCases, useCase) {
+			return fmt.Errorf(`invalid use case "%s"; it needs to be one of %s`, useCase, strings.Join(useCases, ", "))
+		}
+
+		for metric, bounds := range metrics {
+			if !slices.Contains([]string{ScoreMetricDownlink, ScoreMetricUplink, ScoreMetricRTT, ScoreMetricLoadedRTT, ScoreMetricJitter}, metric) {
+				return fmt.Errorf(`invalid metric "%s"; it needs to be one of down, up, rtt, loaded-rtt and jitter`, metric)
+			}
+			if err := validateBounds(useCase+"."+metric, bounds, len(ratings)-1, isScoreMetricHigherBetter(metric)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Set replaces bounds of "bufferbloat" or of a metric of a use case given as "use-case.metric", e.g. "gaming.rtt"
+func (t *ScoreThresholds) Set(key string, bounds []float64) error {
+	if key == "bufferbloat" {
+		t.Bufferbloat = bounds
+		return nil
+	}
+
+	useCase, metric, ok := strings.Cut(key, ".")
+	if !ok {
+		return fmt.Errorf(`invalid score threshold "%s"; it needs to be bufferbloat or use-case.metric, e.g. gaming.rtt`, key)
+	}
+
+	if t.UseCases == nil {
+		t.UseCases = map[string]map[string][]float64{}
+	}
+	if t.UseCases[useCase] == nil {
+		t.UseCases[useCase] = map[string][]float64{}
+	}
+	t.UseCases[useCase][metric] = bounds
+
+	return nil
+}
+
+// Scores summarise a run for humans
+type Scores struct {
+	Bufferbloat     string            `json:"bufferbloat,omitempty"`     // Grade from A+ to F; empty without loaded RTT
+	LatencyIncrease float64           `json:"latencyIncrease,omitempty"` // Increase of mean RTT in ms under the heaviest of the loads measured
+	UseCases        map[string]string `json:"useCases,omitempty"`        // Ratings by use case; use cases of none of their metrics measured are left out
+}
+
+// getLevel locates a value among bounds ordered from the best level to the worst
+func getLevel(value float64, bounds []float64, higherBetter bool) int {
+	for index, bound := range bounds {
+		if (higherBetter && value >= bound) || (!higherBetter && value <= bound) {
+			return index
+		}
+	}
+
+	return len(bounds)
+}
+
+// getScoreMetrics gathers metrics of the run measured
+func getScoreMetrics(run *RunResult) map[string]float64 {
+	metrics := map[string]float64{}
+
+	if run.Downlink != nil {
+		metrics[ScoreMetricDownlink] = run.Downlink.Mean
+	}
+	if run.Uplink != nil {
+		metrics[ScoreMetricUplink] = run.Uplink.Mean
+	}
+	if run.UnloadedRTT != nil {
+		metrics[ScoreMetricRTT] = run.UnloadedRTT.Mean
+		if run.UnloadedRTT.NSamples > 1 {
+			metrics[ScoreMetricJitter] = run.UnloadedRTT.Jitter
+		}
+	}
+
+	for _, loadedRTT := range []*Stats{run.DownlinkLoadedRTT, run.UplinkLoadedRTT, run.BidirectionalLoadedRTT} {
+		if loadedRTT != nil {
+			metrics[ScoreMetricLoadedRTT] = math.Max(metrics[ScoreMetricLoadedRTT], loadedRTT.Mean)
+		}
+	}
+
+	return metrics
+}
+
+// Score grades bufferbloat of the run and rates it for use cases
+func (t *ScoreThresholds) Score(run *RunResult) *Scores {
+	metrics := getScoreMetrics(run)
+	scores := &Scores{
+		UseCases: map[string]string{},
+	}
+
+	loadedRTT, loaded := metrics[ScoreMetricLoadedRTT]
+	if unloadedRTT, unloaded := metrics[ScoreMetricRTT]; loaded && unloaded {
+		scores.LatencyIncrease = math.Max(0, loadedRTT-unloadedRTT)
+		scores.Bufferbloat = bufferbloatGrades[getLevel(scores.LatencyIncrease, t.Bufferbloat, false)]
+	}
+
+	for useCase, bounds := range t.UseCases {
+		level, rated := 0, false
+
+		for metric, metricBounds := range bounds {
+			if value, ok := metrics[metric]; ok {
+				level = max(level, getLevel(value, metricBounds, isScoreMetricHigherBetter(metric)))
+				rated = true
+			}
+		}
+
+		if rated {
+			scores.UseCases[useCase] = ratings[level]
+		}
+	}
+
+	return scores
+}
+
+// getSortedUseCases lists use cases rated in the order of useCases
+func (s *Scores) getSortedUseCases() []string {
+	sorted := []string{}
+	for _, useCase := range useCases {
+		if _, ok := s.UseCases[useCase]; ok {
+			sorted = append(sorted, useCase)
+		}
+	}
+
+	return sorted
+}
+
+// getBufferbloatGradeLevel ranks grades from 5 for A+ to 0 for F, for sinks dealing with numbers only
+func getBufferbloatGradeLevel(grade string) int {
+	return len(bufferbloatGrades) - 1 - slices.Index(bufferbloatGrades, grade)
+}
+
+// getRatingLevel ranks ratings from 4 for great to 0 for bad
+func getRatingLevel(rating string) int {
+	return len(ratings) - 1 - slices.Index(ratings, rating)
+}
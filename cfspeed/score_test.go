@@ -0,0 +1,71 @@
+package cfspeed
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestScoreThresholds_Score(t *testing.T) {
+	run := &RunResult{
+		UnloadedRTT:       getF64Stats([]float64{14, 16, 15, 15}),
+		Downlink:          &SpeedMeasurementStats{Mean: 100},
+		DownlinkLoadedRTT: &Stats{Mean: 55},
+		Uplink:            &SpeedMeasurementStats{Mean: 5},
+		UplinkLoadedRTT:   &Stats{Mean: 40},
+	}
+
+	scores := NewScoreThresholds().Score(run)
+
+	// latency increase of 40 ms under the downlink load
+	assert.Equal(t, scores.Bufferbloat, "B")
+	assert.Equal(t, scores.LatencyIncrease, 40.0)
+	assert.DeepEqual(t, scores.UseCases, map[string]string{
+		UseCaseStreaming:         RatingGreat,
+		UseCaseGaming:            RatingGood,
+		UseCaseVideoConferencing: RatingGood,
+	})
+}
+
+func TestScoreThresholds_ScoreUnloadedOnly(t *testing.T) {
+	run := &RunResult{
+		UnloadedRTT: getF64Stats([]float64{100, 140}),
+	}
+
+	scores := NewScoreThresholds().Score(run)
+
+	assert.Equal(t, scores.Bufferbloat, "")
+	assert.DeepEqual(t, scores.UseCases, map[string]string{
+		UseCaseGaming:            RatingBad,
+		UseCaseVideoConferencing: RatingPoor,
+	})
+	assert.DeepEqual(t, scores.getSortedUseCases(), []string{UseCaseGaming, UseCaseVideoConferencing})
+}
+
+func TestScoreThresholds_Validate(t *testing.T) {
+	assert.NilError(t, NewScoreThresholds().Validate())
+
+	thresholds := NewScoreThresholds()
+	assert.NilError(t, thresholds.Set("gaming.rtt", []float64{10, 20, 30, 40}))
+	assert.NilError(t, thresholds.Validate())
+
+	assert.NilError(t, thresholds.Set("streaming.down", []float64{3, 5, 15, 25}))
+	assert.ErrorContains(t, thresholds.Validate(), "ordered from the best level to the worst")
+
+	thresholds = NewScoreThresholds()
+	assert.NilError(t, thresholds.Set("bufferbloat", []float64{5, 30}))
+	assert.ErrorContains(t, thresholds.Validate(), "5 of them are needed")
+
+	thresholds = NewScoreThresholds()
+	assert.NilError(t, thresholds.Set("browsing.rtt", []float64{10, 20, 30, 40}))
+	assert.ErrorContains(t, thresholds.Validate(), `invalid use case "browsing"`)
+
+	assert.ErrorContains(t, NewScoreThresholds().Set("gaming", nil), "use-case.metric")
+}
+
+func TestGetRatingLevel(t *testing.T) {
+	assert.Equal(t, getRatingLevel(RatingGreat), 4)
+	assert.Equal(t, getRatingLevel(RatingBad), 0)
+	assert.Equal(t, getBufferbloatGradeLevel("A+"), 5)
+	assert.Equal(t, getBufferbloatGradeLevel("F"), 0)
+}
@@ -0,0 +1,148 @@
+package cfspeed
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultServerDownloadSizeMax = 1024 * 1024 * 1024 // Maximum size of data to be served per download request; 1 GiB
+
+	serverWriteChunkSize = 64 * 1024
+)
+
+// ServerOptions determines the metadata reported to clients by Server.
+// Empty fields are omitted from the response headers, except SrcIP which defaults to the remote address of the client.
+type ServerOptions struct {
+	SrcIP           string
+	SrcASN          string
+	SrcCity         string
+	SrcCountry      string
+	DstColo         string
+	DownloadSizeMax int64
+}
+
+// Server implements the subset of the API of speed.cloudflare.com that cfspeed relies on
+type Server struct {
+	opts      ServerOptions
+	zeroChunk []byte
+}
+
+func NewServer(opts *ServerOptions) *Server {
+	s := &Server{
+		opts:      *opts,
+		zeroChunk: make([]byte, serverWriteChunkSize),
+	}
+
+	if s.opts.DownloadSizeMax <= 0 {
+		s.opts.DownloadSizeMax = defaultServerDownloadSizeMax
+	}
+
+	return s
+}
+
+func formatServerTiming(reqDur time.Duration) string {
+	return fmt.Sprintf("cfRequestDuration;dur=%.3f", float64(reqDur.Nanoseconds())/1000/1000)
+}
+
+func (s *Server) setMetaHeaders(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+
+	srcIP := s.opts.SrcIP
+	if srcIP == "" {
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			srcIP = host
+		}
+	}
+
+	for key, value := range map[string]string{
+		"cf-meta-ip":      srcIP,
+		"cf-meta-asn":     s.opts.SrcASN,
+		"cf-meta-city":    s.opts.SrcCity,
+		"cf-meta-country": s.opts.SrcCountry,
+		"cf-meta-colo":    s.opts.DstColo,
+	} {
+		if value != "" {
+			header.Set(key, value)
+		}
+	}
+
+	header.Set("Cache-Control", "no-store")
+}
+
+func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	size := int64(0)
+	if sizeStr := r.URL.Query().Get("bytes"); sizeStr != "" {
+		var err error = nil
+		size, err = strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "invalid bytes", http.StatusBadRequest)
+			return
+		}
+	}
+	if size > s.opts.DownloadSizeMax {
+		size = s.opts.DownloadSizeMax
+	}
+
+	s.setMetaHeaders(w, r)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Server-Timing", formatServerTiming(time.Since(start)))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	for remaining := size; remaining > 0; {
+		chunk := s.zeroChunk
+		if remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+
+		written, err := w.Write(chunk)
+		if err != nil {
+			return
+		}
+		remaining -= int64(written)
+	}
+}
+
+func (s *Server) handleUp(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		return
+	}
+
+	s.setMetaHeaders(w, r)
+	w.Header().Set("Server-Timing", formatServerTiming(time.Since(start)))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case downPath:
+		s.handleDown(w, r)
+	case upPath:
+		s.handleUp(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
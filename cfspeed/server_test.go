@@ -0,0 +1,103 @@
+package cfspeed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func startDummyServer(t *testing.T) *Client {
+	server := httptest.NewServer(NewServer(&ServerOptions{
+		SrcIP:      "192.0.2.1",
+		SrcASN:     "64496",
+		SrcCity:    "Tokyo",
+		SrcCountry: "JP",
+		DstColo:    "NRT",
+	}))
+	t.Cleanup(server.Close)
+
+	config := NewConfig()
+	config.BaseURL = server.URL
+
+	client, err := NewClient(config)
+	assert.NilError(t, err)
+	t.Cleanup(client.CloseIdleConnections)
+
+	return client
+}
+
+func TestServer_Metadata(t *testing.T) {
+	client := startDummyServer(t)
+
+	metadata, err := client.GetMeasurementMetadata(context.Background())
+	assert.NilError(t, err)
+
+	// the request fetching metadata establishes the connection
+	assert.Assert(t, metadata.Phases != nil && metadata.Phases.Connect != nil)
+	metadata.Phases = nil
+
+	assert.DeepEqual(t, metadata, &MeasurementMetadata{
+		SrcIP:      "192.0.2.1",
+		SrcASN:     "64496",
+		SrcCity:    "Tokyo",
+		SrcCountry: "JP",
+		DstColo:    "NRT",
+	})
+}
+
+func TestServer_Downlink(t *testing.T) {
+	client := startDummyServer(t)
+
+	measurement, err := client.doDownlinkMeasurement(context.Background(), 4*1024*1024, time.Now().Add(5*time.Second))
+	assert.NilError(t, err)
+
+	assert.Equal(t, measurement.Direction, DirectionDownlink)
+	assert.Equal(t, measurement.Size, int64(4*1024*1024))
+	assert.Assert(t, len(measurement.IOSampler.Events) > 0)
+	assert.Assert(t, measurement.HTTPRespHeader.Get("Server-Timing") != "")
+}
+
+func TestServer_Uplink(t *testing.T) {
+	client := startDummyServer(t)
+
+	measurement, err := client.doUplinkMeasurement(context.Background(), 4*1024*1024, time.Now().Add(5*time.Second))
+	assert.NilError(t, err)
+
+	assert.Equal(t, measurement.Direction, DirectionUplink)
+	assert.Equal(t, measurement.Size, int64(4*1024*1024))
+	assert.Assert(t, measurement.CFReqDur > 0)
+	assert.Assert(t, measurement.CFReqDur <= measurement.Duration)
+}
+
+func TestServer_RTT(t *testing.T) {
+	client := startDummyServer(t)
+
+	rttStats, cfReqDurStats, err := client.MeasureRTT(context.Background())
+	assert.NilError(t, err)
+
+	assert.Assert(t, rttStats.NSamples > 0)
+	assert.Equal(t, rttStats.NSamples, cfReqDurStats.NSamples)
+}
+
+func TestServer_InvalidRequests(t *testing.T) {
+	client := startDummyServer(t)
+
+	resp, err := http.Get(client.BaseURL + "/__down?bytes=-1")
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+
+	resp, err = http.Get(client.BaseURL + "/__up")
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+
+	resp, err = http.Get(client.BaseURL + "/")
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+}
@@ -11,15 +11,22 @@ const (
 )
 
 type Stats struct {
-	NSamples int
-	Mean     float64
-	StdDev   float64
-	StdErr   float64
-	Min      float64
-	MinIndex int
-	Max      float64
-	MaxIndex int
-	Deciles  []float64
+	NSamples int       `json:"nSamples"`
+	Mean     float64   `json:"mean"`
+	StdDev   float64   `json:"stdDev"`
+	StdErr   float64   `json:"stdErr"`
+	Min      float64   `json:"min"`
+	MinIndex int       `json:"minIndex"`
+	Max      float64   `json:"max"`
+	MaxIndex int       `json:"maxIndex"`
+	Deciles  []float64 `json:"deciles"`
+	Jitter   float64   `json:"jitter"` // Mean of absolute differences between consecutive samples
+
+	// Samples in the order measured from which the stats are derived; retained for exporters building histograms
+	Samples []float64 `json:"samples,omitempty"`
+
+	// Phases of the requests measured, for RTT
+	Phases *ConnPhaseStats `json:"phases,omitempty"`
 }
 
 type Sample[T any] struct {
@@ -60,7 +67,8 @@ func getF64SquareMean(series []float64) float64 {
 }
 
 func getF64StdDevUsingMean(series []float64, mean float64) float64 {
-	return math.Sqrt(getF64SquareMean(series) - (mean * mean))
+	// rounding errors may turn the variance slightly negative
+	return math.Sqrt(math.Max(0, getF64SquareMean(series)-(mean*mean)))
 }
 
 func getF64Deciles(series []float64) []float64 {
@@ -86,6 +94,13 @@ func getF64Deciles(series []float64) []float64 {
 }
 
 func getF64Stats(series []float64) *Stats {
+	// no samples yield all-zero stats rather than infinities and NaNs that cannot be serialised
+	if len(series) == 0 {
+		return &Stats{
+			Deciles: getF64Deciles(series),
+		}
+	}
+
 	ret := &Stats{
 		Min:      math.Inf(1),
 		Max:      math.Inf(-1),
@@ -109,6 +124,31 @@ func getF64Stats(series []float64) *Stats {
 	ret.StdDev = getF64StdDevUsingMean(series, ret.Mean)
 	ret.StdErr = ret.StdDev / math.Sqrt(float64(len(series)))
 	ret.Deciles = getF64Deciles(series)
+	ret.Jitter = getF64Jitter(series)
+
+	return ret
+}
+
+// getF64Jitter takes the mean of absolute differences between consecutive samples, cf. RFC 3550
+func getF64Jitter(series []float64) float64 {
+	if len(series) < 2 {
+		return 0
+	}
+
+	ret := float64(0)
+	nDiffsF64 := float64(len(series) - 1)
+
+	for iter := 1; iter < len(series); iter += 1 {
+		ret += math.Abs(series[iter]-series[iter-1]) / nDiffsF64
+	}
+
+	return ret
+}
+
+// getF64SamplesStats is getF64Stats retaining the samples
+func getF64SamplesStats(series []float64) *Stats {
+	ret := getF64Stats(series)
+	ret.Samples = series
 
 	return ret
 }
@@ -140,7 +180,7 @@ func getDurationMSStats(durations []time.Duration) *Stats {
 		durationSamples[index] = durationMSF64
 	}
 
-	return getF64Stats(durationSamples)
+	return getF64SamplesStats(durationSamples)
 }
 
 func getIOLatencyMSStats(ioEvents []*IOEvent) *Stats {
@@ -381,10 +421,10 @@ func analyseMeasurementGroups(measurementGroups [][]*SpeedMeasurement) ([]*Sampl
 
 func getSingleSpeedMeasurementStats(measurements []*SpeedMeasurement) (*Stats, int64, int64) {
 	mbpsSamples, sizeSum, durationSum := analyseMeasurements(measurements, false)
-	return getF64Stats(getValuesFromSamples(mbpsSamples)), sizeSum, durationSum
+	return getF64SamplesStats(getValuesFromSamples(mbpsSamples)), sizeSum, durationSum
 }
 
 func getMultiplexedSpeedMeasurementStats(measurementGroups [][]*SpeedMeasurement) (*Stats, int64, int64) {
 	mbpsSamples, sizeSum, longestSpan := analyseMeasurementGroups(measurementGroups)
-	return getF64Stats(getValuesFromSamples(mbpsSamples)), sizeSum, longestSpan
+	return getF64SamplesStats(getValuesFromSamples(mbpsSamples)), sizeSum, longestSpan
 }
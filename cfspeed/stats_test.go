@@ -1,6 +1,7 @@
 package cfspeed
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -73,6 +74,8 @@ func TestGetDurationMSStats(t *testing.T) {
 	assert.Equal(t, stats.Max, 236.0)
 	assert.Equal(t, stats.MaxIndex, 5)
 	assert.DeepEqual(t, stats.Deciles, []float64{34, 46, 61, 127, 137, 146, 167, 189, 231})
+	assert.Assert(t, math.Abs(stats.Jitter-99.5) < 1e-9)
+	assert.DeepEqual(t, stats.Samples[:3], []float64{127, 19, 139})
 }
 
 func generateDummyIOEvents(ioMode string, startAt time.Time, eventsAfter []time.Duration, eventSizes []int) []*IOEvent {
@@ -677,3 +680,20 @@ func TestAnalyseMeasurementGroups_WithZeroLengthMeasurements(t *testing.T) {
 	assert.Equal(t, sizeSum, int64(0))
 	assert.Equal(t, time.Duration(longestSpan)*time.Microsecond, 0*time.Microsecond)
 }
+
+func TestGetF64Stats_NoSamples(t *testing.T) {
+	stats := getF64Stats([]float64{})
+
+	assert.Equal(t, stats.NSamples, 0)
+	assert.Equal(t, stats.Mean, 0.0)
+	assert.Equal(t, stats.StdDev, 0.0)
+	assert.Equal(t, stats.StdErr, 0.0)
+	assert.Equal(t, stats.Min, 0.0)
+	assert.Equal(t, stats.Max, 0.0)
+	assert.DeepEqual(t, stats.Deciles, []float64{0, 0, 0, 0, 0, 0, 0, 0, 0})
+}
+
+func TestGetF64Jitter(t *testing.T) {
+	assert.Equal(t, getF64Jitter([]float64{10, 12, 9, 9, 13}), 2.25)
+	assert.Equal(t, getF64Jitter([]float64{10}), 0.0)
+}
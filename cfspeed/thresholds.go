@@ -0,0 +1,147 @@
+package cfspeed
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	SpeedStatisticMean = "mean"
+	SpeedStatisticCat  = "cat"
+	SpeedStatisticMin  = "min"
+	SpeedStatisticMax  = "max"
+)
+
+// Thresholds are the levels a run is expected to meet. Zero disables the corresponding check.
+type Thresholds struct {
+	MinDownlink    float64 // Minimum downlink speed in Mbps
+	MinUplink      float64 // Minimum uplink speed in Mbps
+	MaxRTT         float64 // Maximum mean of unloaded RTT in ms
+	MaxLoadedRTT   float64 // Maximum mean of RTT in ms while either of downlink and uplink is loaded
+	SpeedStatistic string  // Statistic compared with speed thresholds; "mean", "cat", "min", "max" or "d1" to "d9" for deciles
+}
+
+type ThresholdCheck struct {
+	Name     string  `json:"name"`
+	Measured bool    `json:"measured"`
+	Observed float64 `json:"observed"`
+	Limit    float64 `json:"limit"`
+	Unit     string  `json:"unit"`
+	Passed   bool    `json:"passed"`
+}
+
+func (t *Thresholds) Validate() error {
+	if t.MinDownlink < 0 || t.MinUplink < 0 || t.MaxRTT < 0 || t.MaxLoadedRTT < 0 {
+		return fmt.Errorf("thresholds need to be non-negative")
+	}
+
+	if _, err := getSpeedStatistic(&SpeedMeasurementStats{Deciles: make([]float64, 9)}, t.SpeedStatistic); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *Thresholds) HasRTTChecks() bool {
+	return t.MaxRTT > 0 || t.MaxLoadedRTT > 0
+}
+
+func getSpeedStatistic(stats *SpeedMeasurementStats, statistic string) (float64, error) {
+	switch statistic {
+	case SpeedStatisticMean, "":
+		return stats.Mean, nil
+	case SpeedStatisticCat:
+		return stats.CatSpeed, nil
+	case SpeedStatisticMin:
+		return stats.Min, nil
+	case SpeedStatisticMax:
+		return stats.Max, nil
+	}
+
+	if decileStr, ok := strings.CutPrefix(statistic, "d"); ok {
+		if decile, err := strconv.Atoi(decileStr); err == nil && decile >= 1 && decile <= 9 && len(stats.Deciles) == 9 {
+			return stats.Deciles[decile-1], nil
+		}
+	}
+
+	return 0, fmt.Errorf(`invalid speed statistic "%s"; it needs to be one of mean, cat, min, max and d1 to d9`, statistic)
+}
+
+func checkMin(name string, observed float64, measured bool, limit float64, unit string) *ThresholdCheck {
+	return &ThresholdCheck{
+		Name:     name,
+		Measured: measured,
+		Observed: observed,
+		Limit:    limit,
+		Unit:     unit,
+		Passed:   measured && observed >= limit,
+	}
+}
+
+func checkMax(name string, observed float64, measured bool, limit float64, unit string) *ThresholdCheck {
+	return &ThresholdCheck{
+		Name:     name,
+		Measured: measured,
+		Observed: observed,
+		Limit:    limit,
+		Unit:     unit,
+		Passed:   measured && observed <= limit,
+	}
+}
+
+func (t *Thresholds) checkSpeed(name string, stats *SpeedMeasurementStats, limit float64) *ThresholdCheck {
+	if stats == nil {
+		return checkMin(name, 0, false, limit, "Mbps")
+	}
+
+	observed, _ := getSpeedStatistic(stats, t.SpeedStatistic)
+	return checkMin(name, observed, true, limit, "Mbps")
+}
+
+func checkRTT(name string, stats *Stats, limit float64) *ThresholdCheck {
+	if stats == nil {
+		return checkMax(name, 0, false, limit, "ms")
+	}
+
+	return checkMax(name, stats.Mean, true, limit, "ms")
+}
+
+// Evaluate checks the run against the thresholds enabled. Checks of phases not measured are not evaluated, neither passing nor failing.
+func (t *Thresholds) Evaluate(run *RunResult) []*ThresholdCheck {
+	return t.evaluate(run, true, true)
+}
+
+// evaluate checks the run against the thresholds enabled, leaving out checks of speed measurements not selected
+func (t *Thresholds) evaluate(run *RunResult, downlink bool, uplink bool) []*ThresholdCheck {
+	checks := []*ThresholdCheck{}
+
+	if t.MinDownlink > 0 && downlink {
+		checks = append(checks, t.checkSpeed("min-down", run.Downlink, t.MinDownlink))
+	}
+	if t.MinUplink > 0 && uplink {
+		checks = append(checks, t.checkSpeed("min-up", run.Uplink, t.MinUplink))
+	}
+	if t.MaxRTT > 0 {
+		checks = append(checks, checkRTT("max-rtt", run.UnloadedRTT, t.MaxRTT))
+	}
+	if t.MaxLoadedRTT > 0 && downlink {
+		checks = append(checks, checkRTT("max-loaded-rtt-down", run.DownlinkLoadedRTT, t.MaxLoadedRTT))
+	}
+	if t.MaxLoadedRTT > 0 && uplink {
+		checks = append(checks, checkRTT("max-loaded-rtt-up", run.UplinkLoadedRTT, t.MaxLoadedRTT))
+	}
+
+	return checks
+}
+
+// ChecksPassed tells whether all the checks of the run evaluated passed
+func (r *RunResult) ChecksPassed() bool {
+	for _, check := range r.Checks {
+		if check.Measured && !check.Passed {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,71 @@
+package cfspeed
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestThresholds_Evaluate(t *testing.T) {
+	run := generateDummyRunResult()
+
+	checks := (&Thresholds{
+		MinDownlink:    95,
+		MinUplink:      10,
+		MaxRTT:         11,
+		MaxLoadedRTT:   100,
+		SpeedStatistic: "d1",
+	}).Evaluate(run)
+
+	assert.DeepEqual(t, checks, []*ThresholdCheck{
+		{Name: "min-down", Measured: true, Observed: 90, Limit: 95, Unit: "Mbps", Passed: false},
+		{Name: "min-up", Measured: false, Observed: 0, Limit: 10, Unit: "Mbps", Passed: false},
+		{Name: "max-rtt", Measured: true, Observed: 12, Limit: 11, Unit: "ms", Passed: false},
+		{Name: "max-loaded-rtt-down", Measured: false, Observed: 0, Limit: 100, Unit: "ms", Passed: false},
+		{Name: "max-loaded-rtt-up", Measured: false, Observed: 0, Limit: 100, Unit: "ms", Passed: false},
+	})
+}
+
+func TestThresholds_EvaluatePassing(t *testing.T) {
+	run := generateDummyRunResult()
+	run.Checks = (&Thresholds{
+		MinDownlink:    99,
+		MaxRTT:         12,
+		SpeedStatistic: SpeedStatisticCat,
+	}).Evaluate(run)
+
+	assert.Equal(t, len(run.Checks), 2)
+	assert.Assert(t, run.ChecksPassed())
+}
+
+func TestRunResult_ChecksPassedIgnoresUnmeasured(t *testing.T) {
+	run := &RunResult{Checks: []*ThresholdCheck{
+		{Name: "min-down", Measured: true, Passed: true},
+		{Name: "max-loaded-rtt-down", Measured: false, Passed: false},
+	}}
+	assert.Assert(t, run.ChecksPassed())
+
+	run.Checks[0].Passed = false
+	assert.Assert(t, !run.ChecksPassed())
+}
+
+func TestThresholds_Validate(t *testing.T) {
+	assert.NilError(t, (&Thresholds{SpeedStatistic: "d9"}).Validate())
+	assert.ErrorContains(t, (&Thresholds{SpeedStatistic: "d10"}).Validate(), "invalid speed statistic")
+	assert.ErrorContains(t, (&Thresholds{SpeedStatistic: "median"}).Validate(), "invalid speed statistic")
+	assert.ErrorContains(t, (&Thresholds{MinDownlink: -1}).Validate(), "non-negative")
+}
+
+func TestThresholds_EvaluateSkippedPhases(t *testing.T) {
+	run := generateDummyRunResult()
+
+	checks := (&Thresholds{
+		MinDownlink:  95,
+		MinUplink:    10,
+		MaxLoadedRTT: 100,
+	}).evaluate(run, true, false)
+
+	assert.Equal(t, len(checks), 2)
+	assert.Equal(t, checks[0].Name, "min-down")
+	assert.Equal(t, checks[1].Name, "max-loaded-rtt-down")
+}
@@ -0,0 +1,222 @@
+package cfspeed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TraceSchemaVersion is bumped whenever the structure of TraceRecord changes in an incompatible way
+const TraceSchemaVersion = 2
+
+type TracedSpeedMeasurement struct {
+	Group int `json:"group"` // Index of the connection by which the measurement was made
+	*SpeedMeasurement
+}
+
+type SpeedTrace struct {
+	Multiplexed  bool                      `json:"multiplexed"`
+	Multiplicity int                       `json:"multiplicity"`
+	Truncated    bool                      `json:"truncated,omitempty"` // Whether the measurement was cut short by the data budget
+	Measurements []*TracedSpeedMeasurement `json:"measurements"`
+}
+
+type RunTrace struct {
+	Result   *RunResult  `json:"result"` // Result as of the recording
+	Downlink *SpeedTrace `json:"downlink,omitempty"`
+	Uplink   *SpeedTrace `json:"uplink,omitempty"`
+
+	BidirectionalDownlink *SpeedTrace `json:"bidirectionalDownlink,omitempty"`
+	BidirectionalUplink   *SpeedTrace `json:"bidirectionalUplink,omitempty"`
+}
+
+// TraceRecord is a line of a trace file, which is written as soon as the run completes
+type TraceRecord struct {
+	SchemaVersion int `json:"schemaVersion"`
+	*RunTrace
+}
+
+// Trace holds raw measurements of runs so that they can be analysed offline
+type Trace struct {
+	Runs []*RunTrace
+}
+
+func newSpeedTrace(stats *SpeedMeasurementStats) *SpeedTrace {
+	if stats == nil || stats.Measurements == nil {
+		return nil
+	}
+
+	trace := &SpeedTrace{
+		Multiplexed:  stats.Multiplexed,
+		Multiplicity: len(stats.Measurements),
+		Truncated:    stats.Truncated,
+		Measurements: []*TracedSpeedMeasurement{},
+	}
+
+	for group, measurements := range stats.Measurements {
+		for _, measurement := range measurements {
+			trace.Measurements = append(trace.Measurements, &TracedSpeedMeasurement{
+				Group:            group,
+				SpeedMeasurement: measurement,
+			})
+		}
+	}
+
+	return trace
+}
+
+func (s *SpeedTrace) groupMeasurements() ([][]*SpeedMeasurement, error) {
+	groupedMeasurements := make([][]*SpeedMeasurement, s.Multiplicity)
+
+	for index := range groupedMeasurements {
+		groupedMeasurements[index] = []*SpeedMeasurement{}
+	}
+
+	for _, traced := range s.Measurements {
+		if traced.Group < 0 || traced.Group >= s.Multiplicity || traced.SpeedMeasurement == nil {
+			return nil, fmt.Errorf("invalid measurement of group %d in a trace of multiplicity %d", traced.Group, s.Multiplicity)
+		}
+
+		// the analysis works on the I/O events, which a truncated or edited trace may lack
+		if len(traced.SpeedMeasurement.IOSampler.Events) == 0 {
+			return nil, fmt.Errorf("invalid measurement of group %d without I/O events", traced.Group)
+		}
+
+		groupedMeasurements[traced.Group] = append(groupedMeasurements[traced.Group], traced.SpeedMeasurement)
+	}
+
+	return groupedMeasurements, nil
+}
+
+func (s *SpeedTrace) analyse() (*SpeedMeasurementStats, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	groupedMeasurements, err := s.groupMeasurements()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := AnalyseSpeedMeasurements(groupedMeasurements, s.Multiplexed)
+	stats.Truncated = s.Truncated
+
+	return stats, nil
+}
+
+// Analyse derives results afresh from the raw measurements of the run, checking and scoring them as Run does with opts.
+// Phases not traced, e.g. metadata and RTT, are carried over from the recorded result, whereas its checks and scores are not.
+func (r *RunTrace) Analyse(opts *RunOptions) (*RunResult, error) {
+	if r.Result == nil {
+		return nil, fmt.Errorf("trace has no result")
+	}
+
+	result := &RunResult{
+		Timestamp:              r.Result.Timestamp,
+		TransportProtocol:      r.Result.TransportProtocol,
+		Metadata:               r.Result.Metadata,
+		UnloadedRTT:            r.Result.UnloadedRTT,
+		DownlinkLoadedRTT:      r.Result.DownlinkLoadedRTT,
+		UplinkLoadedRTT:        r.Result.UplinkLoadedRTT,
+		BidirectionalLoadedRTT: r.Result.BidirectionalLoadedRTT,
+		Responsiveness:         r.Result.Responsiveness,
+		Warnings:               r.Result.Warnings,
+		Error:                  r.Result.Error,
+	}
+	var err error = nil
+
+	if result.Downlink, err = r.Downlink.analyse(); err != nil {
+		return nil, err
+	}
+	if result.Uplink, err = r.Uplink.analyse(); err != nil {
+		return nil, err
+	}
+	if result.BidirectionalDownlink, err = r.BidirectionalDownlink.analyse(); err != nil {
+		return nil, err
+	}
+	if result.BidirectionalUplink, err = r.BidirectionalUplink.analyse(); err != nil {
+		return nil, err
+	}
+
+	// as in Run, failed runs are neither checked nor scored, and checks are limited to the phases measured
+	if result.Error == "" {
+		runOpts := *opts
+		runOpts.SkipDownlink = r.Downlink == nil
+		runOpts.SkipUplink = r.Uplink == nil
+		assessRun(result, &runOpts)
+	}
+
+	return result, nil
+}
+
+// LoadTrace reads runs recorded in a trace file.
+// Malformed lines, e.g. one truncated as the recording process was killed, are skipped.
+func LoadTrace(r io.Reader) (*Trace, error) {
+	trace := &Trace{
+		Runs: []*RunTrace{},
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			record := &TraceRecord{}
+			switch {
+			case json.Unmarshal(line, record) != nil:
+			case record.SchemaVersion != TraceSchemaVersion:
+				return nil, fmt.Errorf("unsupported trace schema version %d", record.SchemaVersion)
+			case record.RunTrace != nil:
+				trace.Runs = append(trace.Runs, record.RunTrace)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return trace, nil
+}
+
+type traceResultWriter struct {
+	w io.Writer
+}
+
+// NewTraceResultWriter records raw measurements of runs in JSON Lines, a TraceRecord per run as it completes.
+// Runs need to be made with RunOptions.KeepMeasurements for speed measurements to be recorded.
+func NewTraceResultWriter(w io.Writer) ResultWriter {
+	return &traceResultWriter{
+		w: w,
+	}
+}
+
+func (t *traceResultWriter) WriteRun(run *RunResult) error {
+	line, err := json.Marshal(&TraceRecord{
+		SchemaVersion: TraceSchemaVersion,
+		RunTrace: &RunTrace{
+			Result:   run,
+			Downlink: newSpeedTrace(run.Downlink),
+			Uplink:   newSpeedTrace(run.Uplink),
+
+			BidirectionalDownlink: newSpeedTrace(run.BidirectionalDownlink),
+			BidirectionalUplink:   newSpeedTrace(run.BidirectionalUplink),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// a single write per record leaves complete records behind even if the process gets killed
+	_, err = t.w.Write(append(line, '\n'))
+	return err
+}
+
+func (t *traceResultWriter) Close() error {
+	return nil
+}
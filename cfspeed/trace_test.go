@@ -0,0 +1,136 @@
+package cfspeed
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func generateDummyGroupedMeasurements() [][]*SpeedMeasurement {
+	groupedMeasurements := [][]*SpeedMeasurement{}
+	dummyStart := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	dummyCFReqDur := 20 * time.Millisecond
+	dummyDuration := 1000*time.Millisecond + dummyCFReqDur
+
+	for group := 0; group < 2; group += 1 {
+		groupStart := dummyStart.Add(time.Duration(group) * 300 * time.Millisecond)
+
+		groupedMeasurements = append(groupedMeasurements, []*SpeedMeasurement{
+			{
+				Direction: DirectionDownlink,
+				Size:      int64(50 * 1000 * 1000),
+				Start:     groupStart,
+				End:       groupStart.Add(dummyDuration),
+				Duration:  dummyDuration,
+				IOSampler: IOSampler{
+					Events: generateDummyIOEvents(
+						IOModeWrite,
+						groupStart,
+						[]time.Duration{dummyCFReqDur + 200*time.Millisecond, dummyCFReqDur + 500*time.Millisecond, dummyDuration},
+						[]int{5 * 1000 * 1000, 15 * 1000 * 1000, 30 * 1000 * 1000},
+					),
+				},
+				CFReqDur: dummyCFReqDur,
+			},
+		})
+	}
+
+	return groupedMeasurements
+}
+
+func TestTrace_RoundTrip(t *testing.T) {
+	for _, multiplexed := range []bool{true, false} {
+		stats := AnalyseSpeedMeasurements(generateDummyGroupedMeasurements(), multiplexed)
+		stats.Truncated = true
+
+		buf := &bytes.Buffer{}
+		traceWriter := NewTraceResultWriter(buf)
+		assert.NilError(t, traceWriter.WriteRun(&RunResult{
+			TransportProtocol: "tcp",
+			UnloadedRTT:       getF64Stats([]float64{10, 12, 14}),
+			Downlink:          stats,
+			Checks:            []*ThresholdCheck{{Name: "min-down", Measured: true}},
+			Scores:            &Scores{Bufferbloat: "F"},
+		}))
+		// records are written as runs complete rather than on Close
+		assert.Equal(t, strings.Count(buf.String(), "\n"), 1)
+		assert.NilError(t, traceWriter.Close())
+
+		trace, err := LoadTrace(buf)
+		assert.NilError(t, err)
+		assert.Equal(t, len(trace.Runs), 1)
+		assert.Equal(t, trace.Runs[0].Downlink.Multiplexed, multiplexed)
+		assert.Equal(t, len(trace.Runs[0].Downlink.Measurements), 2)
+		assert.Equal(t, trace.Runs[0].Downlink.Measurements[1].Group, 1)
+		assert.Assert(t, trace.Runs[0].Uplink == nil)
+
+		result, err := trace.Runs[0].Analyse(&RunOptions{})
+		assert.NilError(t, err)
+
+		assert.Equal(t, result.TransportProtocol, "tcp")
+		assert.Assert(t, result.Checks == nil && result.Scores == nil)
+		assert.Assert(t, result.Uplink == nil)
+		assert.DeepEqual(t, result.Downlink.Measurements, stats.Measurements)
+
+		result.Downlink.Measurements = nil
+		stats.Measurements = nil
+		assert.DeepEqual(t, result.Downlink, stats)
+	}
+}
+
+func TestRunTrace_AnalyseAssesses(t *testing.T) {
+	stats := AnalyseSpeedMeasurements(generateDummyGroupedMeasurements(), true)
+	runTrace := &RunTrace{
+		Result: &RunResult{
+			TransportProtocol: "tcp",
+			UnloadedRTT:       getF64Stats([]float64{10, 12, 14}),
+			Downlink:          stats,
+			Scores:            &Scores{Bufferbloat: "F"},
+		},
+		Downlink: newSpeedTrace(stats),
+	}
+
+	result, err := runTrace.Analyse(&RunOptions{
+		Thresholds:      &Thresholds{MinDownlink: 1, MinUplink: 1},
+		ScoreThresholds: NewScoreThresholds(),
+	})
+	assert.NilError(t, err)
+
+	// uplink was not measured and hence not checked, as in live runs
+	assert.Equal(t, len(result.Checks), 1)
+	assert.Assert(t, result.ChecksPassed())
+	assert.Equal(t, result.Scores.Bufferbloat, "")
+	assert.Assert(t, result.Scores.UseCases[UseCaseStreaming] != "")
+}
+
+func TestLoadTrace_TruncatedRecord(t *testing.T) {
+	trace, err := LoadTrace(bytes.NewBufferString(`{"schemaVersion":2,"result":{"transportProtocol":"tcp4"}}
+{"schemaVersion":2,"result":{"transportProtocol":"tcp6"},"downlink":{"multi`))
+	assert.NilError(t, err)
+	assert.Equal(t, len(trace.Runs), 1)
+	assert.Equal(t, trace.Runs[0].Result.TransportProtocol, "tcp4")
+}
+
+func TestLoadTrace_InvalidGroup(t *testing.T) {
+	trace, err := LoadTrace(bytes.NewBufferString(`{"schemaVersion":2,"result":{},"downlink":{"multiplexed":true,"multiplicity":1,"measurements":[{"group":1}]}}`))
+	assert.NilError(t, err)
+
+	_, err = trace.Runs[0].Analyse(&RunOptions{})
+	assert.ErrorContains(t, err, "invalid measurement of group 1")
+}
+
+func TestLoadTrace_NoEvents(t *testing.T) {
+	trace, err := LoadTrace(bytes.NewBufferString(`{"schemaVersion":2,"result":{},"downlink":{"multiplexed":true,"multiplicity":1,"measurements":[{"group":0,"direction":"down","ioSampler":{"events":[]}}]}}`))
+	assert.NilError(t, err)
+
+	_, err = trace.Runs[0].Analyse(&RunOptions{})
+	assert.ErrorContains(t, err, "without I/O events")
+}
+
+func TestLoadTrace_UnsupportedVersion(t *testing.T) {
+	_, err := LoadTrace(bytes.NewBufferString(`{"schemaVersion":1,"runs":[]}`))
+	assert.ErrorContains(t, err, "unsupported trace schema version 1")
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileName = "config.yaml"
+	envPrefix      = "CFSPEED_"
+)
+
+// ConfigFile holds settings keyed by flag names.
+// Defaults apply to every invocation, and a profile selected with --profile applies on top of them.
+type ConfigFile struct {
+	Defaults map[string]any            `yaml:"defaults"`
+	Profiles map[string]map[string]any `yaml:"profiles"`
+}
+
+type ConfigFileOpts struct {
+	path    string
+	profile string
+}
+
+func getDefaultConfigFilePath() (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configDir = filepath.Join(homeDir, ".config")
+	}
+
+	return filepath.Join(configDir, "cfspeed", configFileName), nil
+}
+
+// loadConfigFile reads the config file; a missing file at the default path is no error and yields nil
+func loadConfigFile(path string) (*ConfigFile, error) {
+	explicit := path != ""
+	if !explicit {
+		defaultPath, err := getDefaultConfigFilePath()
+		if err != nil {
+			return nil, nil
+		}
+		path = defaultPath
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+
+	configFile := &ConfigFile{}
+	if err := yaml.Unmarshal(content, configFile); err != nil {
+		return nil, fmt.Errorf(`invalid config file "%s": %w`, path, err)
+	}
+
+	return configFile, nil
+}
+
+// formatConfigValue renders a value of the config file in the syntax of flags; lists and maps are comma-separated
+func formatConfigValue(value any) string {
+	switch typedValue := value.(type) {
+	case []any:
+		items := make([]string, len(typedValue))
+		for index, item := range typedValue {
+			items[index] = formatConfigValue(item)
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		items := []string{}
+		for key, item := range typedValue {
+			items = append(items, fmt.Sprintf("%s=%s", key, formatConfigValue(item)))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(typedValue)
+	}
+}
+
+// applyConfigSettings sets flags from settings of the config file unless they are to be kept as they are
+func applyConfigSettings(flags *pflag.FlagSet, settings map[string]any, section string, keep map[string]bool) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		flag := flags.Lookup(name)
+		if flag == nil || name == "config" || name == "profile" {
+			return fmt.Errorf(`unknown setting "%s" in %s of config file`, name, section)
+		}
+		if keep[name] {
+			continue
+		}
+
+		if err := flags.Set(name, formatConfigValue(settings[name])); err != nil {
+			return fmt.Errorf(`invalid setting "%s" in %s of config file: %w`, name, section, err)
+		}
+	}
+
+	return nil
+}
+
+// getEnvName tells the environment variable corresponding to a flag, e.g. CFSPEED_MIN_DOWN for --min-down
+func getEnvName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvironment sets flags from CFSPEED_* environment variables, returning the names of flags set
+func applyEnvironment(flags *pflag.FlagSet) (map[string]bool, error) {
+	applied := map[string]bool{}
+	var err error = nil
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		value, ok := os.LookupEnv(getEnvName(flag.Name))
+		if !ok || err != nil {
+			return
+		}
+
+		if setErr := flags.Set(flag.Name, value); setErr != nil {
+			err = fmt.Errorf(`invalid environment variable %s: %w`, getEnvName(flag.Name), setErr)
+			return
+		}
+		applied[flag.Name] = true
+	})
+
+	return applied, err
+}
+
+// applySettings layers settings onto flags parsed from the command line.
+// Environment variables override flags, which override the profile, which overrides the defaults of the config file.
+func applySettings(flags *pflag.FlagSet, configFileOpts *ConfigFileOpts) error {
+	keep := map[string]bool{}
+	flags.Visit(func(flag *pflag.Flag) {
+		keep[flag.Name] = true
+	})
+
+	appliedFromEnv, err := applyEnvironment(flags)
+	if err != nil {
+		return err
+	}
+	for name := range appliedFromEnv {
+		keep[name] = true
+	}
+
+	configFile, err := loadConfigFile(configFileOpts.path)
+	if err != nil {
+		return err
+	}
+	if configFile == nil {
+		if configFileOpts.profile != "" {
+			return fmt.Errorf(`profile "%s" not found as there is no config file`, configFileOpts.profile)
+		}
+		return nil
+	}
+
+	var profile map[string]any = nil
+	if configFileOpts.profile != "" {
+		var ok bool
+		if profile, ok = configFile.Profiles[configFileOpts.profile]; !ok {
+			return fmt.Errorf(`profile "%s" not found in config file`, configFileOpts.profile)
+		}
+	}
+
+	// the profile is applied first as the defaults must not override it
+	if err := applyConfigSettings(flags, profile, fmt.Sprintf(`profile "%s"`, configFileOpts.profile), keep); err != nil {
+		return err
+	}
+	for name := range profile {
+		keep[name] = true
+	}
+
+	return applyConfigSettings(flags, configFile.Defaults, "defaults", keep)
+}
+
+func addConfigFileFlags(flags *pflag.FlagSet, configFileOpts *ConfigFileOpts) {
+	flags.StringVar(&configFileOpts.path, "config", "", "config file (default: $XDG_CONFIG_HOME/cfspeed/config.yaml)")
+	flags.StringVar(&configFileOpts.profile, "profile", "", "profile of the config file to apply")
+}
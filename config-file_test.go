@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gotest.tools/v3/assert"
+)
+
+const testConfigFile = `
+defaults:
+  server: https://speed.example.com
+  multiplicity: 2
+  duration: 5s
+profiles:
+  quick:
+    duration: 3s
+    max-bytes: 50MB
+    no-ping: true
+  soak-10g:
+    duration: 60s
+    run-timeout: 90s
+    multiplicity: 8
+    webhook-on: [breach, error]
+    otlp-header:
+      Authorization: Bearer secret
+  broken:
+    no-such-flag: 1
+`
+
+type testSettings struct {
+	server       string
+	multiplicity int
+	duration     time.Duration
+	runTimeout   time.Duration
+	maxBytes     int64
+	noPing       bool
+	webhookOn    []string
+	otlpHeader   map[string]string
+	configFile   ConfigFileOpts
+}
+
+func parseTestSettings(t *testing.T, args ...string) (*testSettings, *pflag.FlagSet) {
+	settings := &testSettings{}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+
+	flags.StringVar(&settings.server, "server", "https://speed.cloudflare.com", "")
+	flags.IntVar(&settings.multiplicity, "multiplicity", 1, "")
+	flags.DurationVar(&settings.duration, "duration", 10*time.Second, "")
+	flags.DurationVar(&settings.runTimeout, "run-timeout", 30*time.Second, "")
+	flags.Var(newByteSizeValue(&settings.maxBytes), "max-bytes", "")
+	flags.BoolVar(&settings.noPing, "no-ping", false, "")
+	flags.StringSliceVar(&settings.webhookOn, "webhook-on", []string{"always"}, "")
+	flags.StringToStringVar(&settings.otlpHeader, "otlp-header", map[string]string{}, "")
+	addConfigFileFlags(flags, &settings.configFile)
+
+	assert.NilError(t, flags.Parse(args))
+
+	return settings, flags
+}
+
+func writeTestConfigFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NilError(t, os.WriteFile(path, []byte(testConfigFile), 0o644))
+
+	return path
+}
+
+func TestApplySettings_Precedence(t *testing.T) {
+	path := writeTestConfigFile(t)
+	t.Setenv("CFSPEED_MULTIPLICITY", "16")
+
+	settings, flags := parseTestSettings(t, "--config", path, "--profile", "quick", "--duration", "4s", "--multiplicity", "4")
+	assert.NilError(t, applySettings(flags, &settings.configFile))
+
+	// environment variables override flags, which override the profile, which overrides the defaults
+	assert.Equal(t, settings.multiplicity, 16)
+	assert.Equal(t, settings.duration, 4*time.Second)
+	assert.Equal(t, settings.maxBytes, int64(50*1000*1000))
+	assert.Equal(t, settings.noPing, true)
+	assert.Equal(t, settings.server, "https://speed.example.com")
+	assert.Equal(t, settings.runTimeout, 30*time.Second)
+}
+
+func TestApplySettings_ListsAndMaps(t *testing.T) {
+	path := writeTestConfigFile(t)
+
+	settings, flags := parseTestSettings(t, "--config", path, "--profile", "soak-10g")
+	assert.NilError(t, applySettings(flags, &settings.configFile))
+
+	assert.Equal(t, settings.multiplicity, 8)
+	assert.Equal(t, settings.duration, 60*time.Second)
+	assert.DeepEqual(t, settings.webhookOn, []string{"breach", "error"})
+	assert.DeepEqual(t, settings.otlpHeader, map[string]string{"Authorization": "Bearer secret"})
+}
+
+func TestApplySettings_ProfileFromEnvironment(t *testing.T) {
+	path := writeTestConfigFile(t)
+	t.Setenv("CFSPEED_CONFIG", path)
+	t.Setenv("CFSPEED_PROFILE", "quick")
+
+	settings, flags := parseTestSettings(t, "--profile", "soak-10g")
+	assert.NilError(t, applySettings(flags, &settings.configFile))
+
+	assert.Equal(t, settings.duration, 3*time.Second)
+}
+
+func TestApplySettings_Errors(t *testing.T) {
+	path := writeTestConfigFile(t)
+
+	settings, flags := parseTestSettings(t, "--config", path, "--profile", "broken")
+	assert.ErrorContains(t, applySettings(flags, &settings.configFile), `unknown setting "no-such-flag" in profile "broken" of config file`)
+
+	settings, flags = parseTestSettings(t, "--config", path, "--profile", "missing")
+	assert.ErrorContains(t, applySettings(flags, &settings.configFile), `profile "missing" not found in config file`)
+
+	settings, flags = parseTestSettings(t, "--config", filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, applySettings(flags, &settings.configFile), "could not read config file")
+
+	t.Setenv("CFSPEED_DURATION", "soon")
+	settings, flags = parseTestSettings(t, "--config", path)
+	assert.ErrorContains(t, applySettings(flags, &settings.configFile), "invalid environment variable CFSPEED_DURATION")
+}
+
+func TestApplySettings_NoDefaultConfigFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	settings, flags := parseTestSettings(t)
+	assert.NilError(t, applySettings(flags, &settings.configFile))
+	assert.Equal(t, settings.multiplicity, 1)
+
+	settings, flags = parseTestSettings(t, "--profile", "quick")
+	assert.ErrorContains(t, applySettings(flags, &settings.configFile), `profile "quick" not found as there is no config file`)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a standard 5-field cron expression, i.e. minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+
+	// as in cron(8), days match either field if both of day of month and day of week are restricted
+	dayOfMonthRestricted bool
+	dayOfWeekRestricted  bool
+}
+
+type cronField struct {
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	cronMinute     = cronField{min: 0, max: 59}
+	cronHour       = cronField{min: 0, max: 23}
+	cronDayOfMonth = cronField{min: 1, max: 31}
+	cronMonth      = cronField{min: 1, max: 12, names: []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	cronDayOfWeek  = cronField{min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+func (f *cronField) parseValue(valueStr string) (int, error) {
+	for index, name := range f.names {
+		if name != "" && strings.EqualFold(valueStr, name) {
+			return index, nil
+		}
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf(`invalid value "%s"; it needs to be within %d-%d`, valueStr, f.min, f.max)
+	}
+
+	return value, nil
+}
+
+func (f *cronField) parse(expr string) (uint64, error) {
+	bits := uint64(0)
+
+	for _, term := range strings.Split(expr, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(term, "/")
+
+		step := 1
+		if hasStep {
+			var err error = nil
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf(`invalid step "%s"`, stepStr)
+			}
+		}
+
+		first, last := f.min, f.max
+		if rangeStr != "*" {
+			firstStr, lastStr, isRange := strings.Cut(rangeStr, "-")
+
+			var err error = nil
+			if first, err = f.parseValue(firstStr); err != nil {
+				return 0, err
+			}
+
+			last = first
+			if isRange {
+				if last, err = f.parseValue(lastStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				last = f.max
+			}
+
+			if first > last {
+				return 0, fmt.Errorf(`invalid range "%s"`, rangeStr)
+			}
+		}
+
+		for value := first; value <= last; value += step {
+			bits |= 1 << value
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+
+	fieldExprs := strings.Fields(expr)
+	if len(fieldExprs) != 5 {
+		return nil, fmt.Errorf(`invalid schedule "%s"; it needs to have 5 fields`, expr)
+	}
+
+	schedule := &cronSchedule{
+		dayOfMonthRestricted: fieldExprs[2] != "*",
+		dayOfWeekRestricted:  fieldExprs[4] != "*",
+	}
+
+	for index, field := range []struct {
+		spec *cronField
+		bits *uint64
+	}{
+		{&cronMinute, &schedule.minute},
+		{&cronHour, &schedule.hour},
+		{&cronDayOfMonth, &schedule.dayOfMonth},
+		{&cronMonth, &schedule.month},
+		{&cronDayOfWeek, &schedule.dayOfWeek},
+	} {
+		bits, err := field.spec.parse(fieldExprs[index])
+		if err != nil {
+			return nil, fmt.Errorf(`invalid schedule "%s": %w`, expr, err)
+		}
+		*field.bits = bits
+	}
+
+	// both 0 and 7 stand for Sunday
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+
+	// e.g. "0 0 30 2 *" is syntactically valid but would never start a run
+	if schedule.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf(`invalid schedule "%s"; it never matches`, expr)
+	}
+
+	return schedule, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	domMatches := s.dayOfMonth&(1<<t.Day()) != 0
+	dowMatches := s.dayOfWeek&(1<<int(t.Weekday())) != 0
+
+	if s.dayOfMonthRestricted && s.dayOfWeekRestricted {
+		return domMatches || dowMatches
+	}
+
+	return domMatches && dowMatches
+}
+
+// next returns the earliest time matching the schedule strictly after t, or the zero time if none is found within 5 years
+func (s *cronSchedule) next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+
+	// a matching time is bound to appear within a few years, e.g. on 29th of February
+	for limit := next.AddDate(5, 0, 0); next.Before(limit); {
+		if s.month&(1<<int(next.Month())) == 0 {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !s.matchesDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if s.hour&(1<<next.Hour()) == 0 {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if s.minute&(1<<next.Minute()) == 0 {
+			next = next.Add(time.Minute)
+			continue
+		}
+
+		return next
+	}
+
+	return time.Time{}
+}
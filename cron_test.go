@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func assertCronNext(t *testing.T, expr string, from string, expected string) {
+	t.Helper()
+
+	schedule, err := parseCronSchedule(expr)
+	assert.NilError(t, err)
+
+	fromTime, err := time.Parse(time.RFC3339, from)
+	assert.NilError(t, err)
+
+	assert.Equal(t, schedule.next(fromTime).Format(time.RFC3339), expected)
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	assertCronNext(t, "* * * * *", "2024-04-01T12:00:30Z", "2024-04-01T12:01:00Z")
+	assertCronNext(t, "*/15 * * * *", "2024-04-01T12:00:00Z", "2024-04-01T12:15:00Z")
+	assertCronNext(t, "*/15 * * * *", "2024-04-01T12:50:00Z", "2024-04-01T13:00:00Z")
+	assertCronNext(t, "5 4 * * *", "2024-04-01T12:00:00Z", "2024-04-02T04:05:00Z")
+	assertCronNext(t, "0 9-17/4 * * mon-fri", "2024-04-05T17:30:00Z", "2024-04-08T09:00:00Z")
+	assertCronNext(t, "0 0 29 feb *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z")
+	assertCronNext(t, "0 0 1,15 * 7", "2024-04-02T00:00:00Z", "2024-04-07T00:00:00Z")
+	assertCronNext(t, "@hourly", "2024-12-31T23:59:00Z", "2025-01-01T00:00:00Z")
+}
+
+func TestParseCronSchedule_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *", "0 0 30 2 *", "0 0 31 apr,jun *"} {
+		_, err := parseCronSchedule(expr)
+		assert.Assert(t, err != nil, expr)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+const (
+	defaultProbeTimeout = 120 * time.Second
+	probeTimeoutOffset  = 500 * time.Millisecond // Margin left for the scraper to receive the response
+)
+
+type ExporterOpts struct {
+	listenAddr   string
+	multiplicity int
+	noRTT        bool
+	config       cfspeed.Config
+}
+
+// exporter serves the results of measurements to Prometheus.
+// Probes are serialised so that overlapping scrapes never make measurements in parallel.
+type exporter struct {
+	opts      *ExporterOpts
+	probeSlot chan struct{}
+
+	lastRunsLock sync.Mutex
+	lastRuns     map[string]*cfspeed.RunResult
+}
+
+func newExporter(opts *ExporterOpts) *exporter {
+	return &exporter{
+		opts:      opts,
+		probeSlot: make(chan struct{}, 1),
+		lastRuns:  map[string]*cfspeed.RunResult{},
+	}
+}
+
+func getProbeTimeout(r *http.Request) time.Duration {
+	scrapeTimeoutSeconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || scrapeTimeoutSeconds <= 0 {
+		return defaultProbeTimeout
+	}
+
+	timeout := time.Duration(scrapeTimeoutSeconds*float64(time.Second)) - probeTimeoutOffset
+	if timeout <= 0 {
+		return time.Duration(scrapeTimeoutSeconds * float64(time.Second))
+	}
+
+	return timeout
+}
+
+func (e *exporter) parseProbeParams(r *http.Request) (string, *cfspeed.RunOptions, error) {
+	query := r.URL.Query()
+
+	network := cfspeed.NetworkTCP
+	switch ip := query.Get("ip"); ip {
+	case "":
+	case "4":
+		network = cfspeed.NetworkTCP4
+	case "6":
+		network = cfspeed.NetworkTCP6
+	default:
+		return "", nil, fmt.Errorf(`invalid ip "%s"; it needs to be either 4 or 6`, ip)
+	}
+
+	runOpts := &cfspeed.RunOptions{
+		Multiplicity:    e.opts.multiplicity,
+		MeasureRTT:      !e.opts.noRTT,
+		ScoreThresholds: cfspeed.NewScoreThresholds(),
+	}
+	if multiplicityStr := query.Get("multiplicity"); multiplicityStr != "" {
+		multiplicity, err := strconv.Atoi(multiplicityStr)
+		if err != nil || multiplicity < 1 {
+			return "", nil, fmt.Errorf(`invalid multiplicity "%s"; it needs to be a positive integer`, multiplicityStr)
+		}
+		runOpts.Multiplicity = multiplicity
+	}
+	if http3Str := query.Get("http3"); http3Str != "" {
+		http3, err := strconv.ParseBool(http3Str)
+		if err != nil {
+			return "", nil, fmt.Errorf(`invalid http3 "%s"`, http3Str)
+		}
+		if http3 {
+			network = getQUICNetwork(network)
+		}
+	}
+	if pingStr := query.Get("ping"); pingStr != "" {
+		ping, err := strconv.ParseBool(pingStr)
+		if err != nil {
+			return "", nil, fmt.Errorf(`invalid ping "%s"`, pingStr)
+		}
+		runOpts.MeasureRTT = ping
+	}
+
+	return network, runOpts, nil
+}
+
+func (e *exporter) probe(ctx context.Context, network string, runOpts *cfspeed.RunOptions) (*cfspeed.RunResult, error) {
+	// wait for the preceding probe, if any, rather than running in parallel with it
+	select {
+	case e.probeSlot <- struct{}{}:
+		defer func() { <-e.probeSlot }()
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out waiting for another probe to complete")
+	}
+
+	config := e.opts.config
+	config.Network = network
+
+	client, err := cfspeed.NewClient(&config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.CloseIdleConnections()
+
+	result, err := cfspeed.Run(ctx, client, runOpts)
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	e.lastRunsLock.Lock()
+	e.lastRuns[network] = result
+	e.lastRunsLock.Unlock()
+
+	return result, nil
+}
+
+// writePrometheusResponse encodes the runs before responding, so that a failure to encode them is told by the status rather than a partial response
+func writePrometheusResponse(w http.ResponseWriter, runs []*cfspeed.RunResult, extraMetrics string) {
+	buf := &bytes.Buffer{}
+
+	fail := func(err error) {
+		errPrinter.Printf("Error: could not encode metrics: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	resultWriter, err := cfspeed.NewResultWriter(cfspeed.FormatPrometheus, buf)
+	if err != nil {
+		fail(err)
+		return
+	}
+	for _, run := range runs {
+		if err := resultWriter.WriteRun(run); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if err := resultWriter.Close(); err != nil {
+		fail(err)
+		return
+	}
+	buf.WriteString(extraMetrics)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		errPrinter.Printf("Error: could not respond with metrics: %v\n", err)
+	}
+}
+
+func (e *exporter) handleProbe(w http.ResponseWriter, r *http.Request) {
+	network, runOpts, err := e.parseProbeParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), getProbeTimeout(r))
+	defer cancel()
+
+	start := time.Now()
+
+	result, err := e.probe(ctx, network, runOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	writePrometheusResponse(w, []*cfspeed.RunResult{result}, fmt.Sprintf(
+		"# HELP cfspeed_probe_duration_seconds Time taken by the probe\n# TYPE cfspeed_probe_duration_seconds gauge\ncfspeed_probe_duration_seconds %s\n",
+		strconv.FormatFloat(time.Since(start).Seconds(), 'g', -1, 64),
+	))
+}
+
+func (e *exporter) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	runs := []*cfspeed.RunResult{}
+
+	e.lastRunsLock.Lock()
+	networks := []string{}
+	for network := range e.lastRuns {
+		networks = append(networks, network)
+	}
+	// in a stable order, e.g. tcp, tcp4, tcp6, udp, udp4 and udp6
+	sort.Strings(networks)
+	for _, network := range networks {
+		runs = append(runs, e.lastRuns[network])
+	}
+	e.lastRunsLock.Unlock()
+
+	writePrometheusResponse(w, runs, "")
+}
+
+func (e *exporter) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/probe", e.handleProbe)
+	mux.HandleFunc("/metrics", e.handleMetrics)
+
+	return mux
+}
+
+func newExporterCommand() *cobra.Command {
+	exporterOpts := &ExporterOpts{
+		config: *cfspeed.NewConfig(),
+	}
+
+	cmd := &cobra.Command{
+		Use:          "exporter",
+		Short:        "Serve measurements to Prometheus",
+		Long:         "Serve measurements to Prometheus.\n\nEvery scrape of /probe?ip=4&multiplicity=4&ping=true&http3=false triggers a run, and /metrics serves the results of the last probes.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if exporterOpts.multiplicity < 1 {
+				return fmt.Errorf(`invalid multiplicity "%d"; it needs to be a positive integer`, exporterOpts.multiplicity)
+			}
+			if err := exporterOpts.config.Validate(); err != nil {
+				return err
+			}
+
+			return runHTTPServer(&http.Server{
+				Addr:              exporterOpts.listenAddr,
+				Handler:           newExporter(exporterOpts).handler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			}, nil, "", "")
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVarP(&exporterOpts.listenAddr, "listen", "l", ":9516", "address to listen on")
+	flags.IntVarP(&exporterOpts.multiplicity, "multiplicity", "m", 1, "default number of connections in parallel for speed measurements")
+	flags.BoolVarP(&exporterOpts.noRTT, "no-ping", "P", false, "do not measure RTT by default")
+	flags.StringVarP(&exporterOpts.config.BaseURL, "server", "s", cfspeed.DefaultBaseURL, "base URL of the speed test server")
+	flags.StringVar(&exporterOpts.config.CACertFile, "ca-cert", "", "PEM file of additional CA certificates to trust")
+	flags.BoolVarP(&exporterOpts.config.Insecure, "insecure", "k", false, "do not verify the server certificate")
+	addMeasurementFlags(flags, &exporterOpts.config)
+
+	return cmd
+}
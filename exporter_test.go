@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+func TestExporter_MetricsOfAllNetworks(t *testing.T) {
+	e := newExporter(&ExporterOpts{})
+	for _, network := range []string{cfspeed.NetworkUDP6, cfspeed.NetworkTCP4, cfspeed.NetworkUDP} {
+		e.lastRuns[network] = &cfspeed.RunResult{TransportProtocol: network, Error: "dummy"}
+	}
+
+	recorder := httptest.NewRecorder()
+	e.handleMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	tcp4At := strings.Index(body, `protocol="tcp4"`)
+	udpAt := strings.Index(body, `protocol="udp"`)
+	udp6At := strings.Index(body, `protocol="udp6"`)
+	assert.Assert(t, tcp4At >= 0 && udpAt > tcp4At && udp6At > udpAt, body)
+}
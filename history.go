@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+const historyFormatTable = "table"
+
+type HistoryOpts struct {
+	file     string
+	since    string
+	until    string
+	protocol string
+	colo     string
+	summary  bool
+	format   string
+}
+
+func getHistoryStore(file string) (*cfspeed.HistoryStore, error) {
+	if file != "" {
+		return &cfspeed.HistoryStore{Path: file}, nil
+	}
+
+	path, err := cfspeed.DefaultHistoryPath()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the location of the history file: %w", err)
+	}
+
+	return &cfspeed.HistoryStore{Path: path}, nil
+}
+
+// parseHistoryTime accepts either RFC 3339 or a date in the local time zone.
+// Dates given as the upper bound include the whole day.
+func parseHistoryTime(timeStr string, isUpperBound bool) (time.Time, error) {
+	if timeStr == "" {
+		return time.Time{}, nil
+	}
+
+	if parsed, err := time.Parse(time.RFC3339, timeStr); err == nil {
+		return parsed, nil
+	}
+
+	parsed, err := time.ParseInLocation(time.DateOnly, timeStr, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(`invalid time "%s"; it needs to be either YYYY-MM-DD or RFC 3339`, timeStr)
+	}
+	if isUpperBound {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+
+	return parsed, nil
+}
+
+func formatHistoryValue(value float64, present bool) string {
+	if !present {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.3f", value)
+}
+
+func printHistoryTable(runs []*cfspeed.RunResult) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "Timestamp\tProtocol\tColo\tDownlink (Mbps)\tUplink (Mbps)\tRTT (ms)\tError")
+	for _, run := range runs {
+		colo := "-"
+		if run.Metadata != nil {
+			colo = run.Metadata.DstColo
+		}
+
+		dlMean, ulMean, rttMean := float64(0), float64(0), float64(0)
+		if run.Downlink != nil {
+			dlMean = run.Downlink.Mean
+		}
+		if run.Uplink != nil {
+			ulMean = run.Uplink.Mean
+		}
+		if run.UnloadedRTT != nil {
+			rttMean = run.UnloadedRTT.Mean
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			run.Timestamp.Local().Format(time.RFC3339),
+			run.TransportProtocol,
+			colo,
+			formatHistoryValue(dlMean, run.Downlink != nil),
+			formatHistoryValue(ulMean, run.Uplink != nil),
+			formatHistoryValue(rttMean, run.UnloadedRTT != nil),
+			run.Error,
+		)
+	}
+
+	return tw.Flush()
+}
+
+func formatHistoryMedian(median *float64) string {
+	if median == nil {
+		return "-"
+	}
+
+	return formatHistoryValue(*median, true)
+}
+
+func printHistoryTrend(name string, slope *float64, unit string) {
+	if slope == nil {
+		printer.Printf("Trend-%s: N/A\n", name)
+		return
+	}
+
+	printer.Printf("Trend-%s: %+.3f %s/day\n", name, *slope, unit)
+}
+
+func printHistorySummary(runs []*cfspeed.RunResult) error {
+	summaries := cfspeed.SummariseHistory(runs, time.Local)
+	trend := cfspeed.GetHistoryTrend(summaries)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintln(tw, "Date\tRuns\tDownlink (Mbps)\tUplink (Mbps)\tRTT (ms)\t")
+	for _, summary := range summaries {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t\n", summary.Date, summary.NRuns, formatHistoryMedian(summary.DownlinkMedian), formatHistoryMedian(summary.UplinkMedian), formatHistoryMedian(summary.UnloadedRTTMedian))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	printer.Println()
+	printHistoryTrend("Downlink", trend.DownlinkPerDay, "Mbps")
+	printHistoryTrend("Uplink", trend.UplinkPerDay, "Mbps")
+	printHistoryTrend("RTT-Unloaded", trend.UnloadedRTTPerDay, "ms")
+
+	return nil
+}
+
+func showHistory(historyOpts *HistoryOpts) error {
+	// summaries are tables only, which is better told than silently ignoring the format
+	if historyOpts.summary && historyOpts.format != historyFormatTable {
+		return fmt.Errorf(`invalid format "%s" for --summary; it needs to be table`, historyOpts.format)
+	}
+
+	historyStore, err := getHistoryStore(historyOpts.file)
+	if err != nil {
+		return err
+	}
+
+	filter := &cfspeed.HistoryFilter{
+		Protocol: historyOpts.protocol,
+		Colo:     historyOpts.colo,
+	}
+	if filter.Since, err = parseHistoryTime(historyOpts.since, false); err != nil {
+		return err
+	}
+	if filter.Until, err = parseHistoryTime(historyOpts.until, true); err != nil {
+		return err
+	}
+
+	runs, err := historyStore.Load(filter)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case historyOpts.summary:
+		return printHistorySummary(runs)
+	case historyOpts.format == historyFormatTable:
+		return printHistoryTable(runs)
+	}
+
+	resultWriter, err := cfspeed.NewResultWriter(historyOpts.format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if err := resultWriter.WriteRun(run); err != nil {
+			return err
+		}
+	}
+
+	return resultWriter.Close()
+}
+
+func newHistoryCommand() *cobra.Command {
+	historyOpts := &HistoryOpts{}
+
+	cmd := &cobra.Command{
+		Use:          "history",
+		Short:        "Show results of past runs",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return showHistory(historyOpts)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVar(&historyOpts.file, "history-file", "", "history file (default: $XDG_DATA_HOME/cfspeed/history.jsonl)")
+	flags.StringVar(&historyOpts.since, "since", "", "show runs at or after this date or time")
+	flags.StringVar(&historyOpts.until, "until", "", "show runs before this time or on or before this date")
+	flags.StringVar(&historyOpts.protocol, "protocol", "", "show runs over this transport protocol only, e.g. tcp4")
+	flags.StringVar(&historyOpts.colo, "colo", "", "show runs against this colocation only")
+	flags.BoolVar(&historyOpts.summary, "summary", false, "summarise runs per day with medians of means and trends")
+	flags.StringVarP(&historyOpts.format, "format", "f", historyFormatTable, "output format (table, text, json, ndjson, csv, prometheus)")
+
+	return cmd
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestShowHistory_SummaryFormat(t *testing.T) {
+	err := showHistory(&HistoryOpts{
+		file:    filepath.Join(t.TempDir(), "history.jsonl"),
+		summary: true,
+		format:  "json",
+	})
+	assert.ErrorContains(t, err, `invalid format "json" for --summary`)
+}
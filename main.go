@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"time"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/makotom/cfspeed/cfspeed"
 )
@@ -15,70 +22,449 @@ var (
 	BuildName       = "\b"
 	BuildAnnotation = "git"
 
-	printer = log.New(os.Stdout, "", 0)
+	printer    = log.New(os.Stdout, "", 0)
+	errPrinter = log.New(os.Stderr, "", 0)
+)
+
+// Exit codes other than 0 for success
+const (
+	exitCodeFailure          = 1 // Measurements failed or the command line was invalid
+	exitCodeThresholdsNotMet = 2 // Measurements succeeded but one or more thresholds were not met
+)
+
+const rootCmdLong = `Unofficial CLI-based implementation of Cloudflare's Speed Test
+
+Exit codes:
+  0  measurements succeeded and thresholds, if any, were met
+  1  measurements failed or the command line was invalid
+  2  measurements succeeded but one or more thresholds were not met`
+
+var errThresholdsNotMet = errors.New("one or more thresholds not met")
+
+// Protocols measurements are made over
+const (
+	protocolTCP   = "tcp"   // HTTP/1.1 or HTTP/2 over TCP
+	protocolHTTP3 = "http3" // HTTP/3 over QUIC on UDP
 )
 
 type CmdOpts struct {
-	testIP4      bool
-	testIP6      bool
-	multiplicity int
-	noRTT        bool
+	testIP4         bool
+	testIP6         bool
+	multiplicity    int
+	noRTT           bool
+	format          string
+	config          cfspeed.Config
+	repeat          RepeatOpts
+	history         bool
+	historyFile     string
+	thresholds      cfspeed.Thresholds
+	scoreBounds     map[string]string
+	scoreThresholds *cfspeed.ScoreThresholds
+	record          string
+	sinks           SinkOpts
+	progress        bool
+	configFile      ConfigFileOpts
+	phases          PhaseOpts
+	bidirectional   bool
+	rpm             bool
+	protocols       []string
+	http3           bool // Shorthand for adding http3 to protocols
 }
 
-func printTimestamp() {
-	printer.Println()
-	printer.Printf("At: %s\n", time.Now().Format(time.RFC1123Z))
-	printer.Println()
+// PhaseOpts determines the phases measured by a command in addition to metadata
+type PhaseOpts struct {
+	rtt            bool // Whether to measure RTT unless --no-ping is given
+	downlink       bool
+	uplink         bool
+	responsiveness bool // Whether to measure RPM regardless of --rpm
 }
 
-func main() {
-	cmdOpts := &CmdOpts{}
+// validateThresholds tells whether the thresholds enabled can be checked with the phases measured
+func (p *PhaseOpts) validateThresholds(thresholds *cfspeed.Thresholds) error {
+	if !p.rtt && thresholds.HasRTTChecks() {
+		return fmt.Errorf("RTT thresholds cannot be checked without RTT measurements")
+	}
+	if !p.downlink && thresholds.MinDownlink > 0 {
+		return fmt.Errorf("--min-down cannot be checked without downlink measurements")
+	}
+	if !p.uplink && thresholds.MinUplink > 0 {
+		return fmt.Errorf("--min-up cannot be checked without uplink measurements")
+	}
+	if !p.downlink && !p.uplink && thresholds.MaxLoadedRTT > 0 {
+		return fmt.Errorf("--max-loaded-rtt cannot be checked without speed measurements")
+	}
 
-	cmd := &cobra.Command{
-		Use:          "cfspeed",
-		Version:      BuildName,
-		SilenceUsage: true,
-		RunE: func(cmd *cobra.Command, _ []string) error {
-			printer.Printf(cmd.VersionTemplate())
+	return nil
+}
 
-			if cmdOpts.multiplicity < 1 {
-				return fmt.Errorf(`invalid multiplicity "%d"; it needs to be a positive integer`, cmdOpts.multiplicity)
-			}
+// getScoreThresholds applies bounds given as "bufferbloat=5/30/60/200/400,gaming.rtt=20/40/60/100" to the defaults
+func getScoreThresholds(scoreBounds map[string]string) (*cfspeed.ScoreThresholds, error) {
+	scoreThresholds := cfspeed.NewScoreThresholds()
 
-			// if none specified, pick up a transport protocol automatically and then exit
-			if !cmdOpts.testIP4 && !cmdOpts.testIP6 {
-				printTimestamp()
-				return cfspeed.RunAndPrint(printer, "tcp", cmdOpts.multiplicity, !cmdOpts.noRTT)
+	for key, boundsStr := range scoreBounds {
+		bounds := []float64{}
+		for _, boundStr := range strings.Split(boundsStr, "/") {
+			bound, err := strconv.ParseFloat(strings.TrimSpace(boundStr), 64)
+			if err != nil {
+				return nil, fmt.Errorf(`invalid bounds "%s" of "%s"; they need to be numbers separated by slashes`, boundsStr, key)
 			}
+			bounds = append(bounds, bound)
+		}
 
-			// these options are not mutually exclusive
-			if cmdOpts.testIP4 {
-				printTimestamp()
-				if err := cfspeed.RunAndPrint(printer, "tcp4", cmdOpts.multiplicity, !cmdOpts.noRTT); err != nil {
-					return err
-				}
-			}
-			if cmdOpts.testIP6 {
-				printTimestamp()
-				if err := cfspeed.RunAndPrint(printer, "tcp6", cmdOpts.multiplicity, !cmdOpts.noRTT); err != nil {
-					return err
-				}
+		if err := scoreThresholds.Set(key, bounds); err != nil {
+			return nil, err
+		}
+	}
+
+	return scoreThresholds, scoreThresholds.Validate()
+}
+
+func runWithNetwork(ctx context.Context, resultWriter cfspeed.ResultWriter, cmdOpts *CmdOpts, network string) (*cfspeed.RunResult, error) {
+	var progress cfspeed.ProgressFunc = nil
+	if cmdOpts.progress {
+		progress = newProgressPrinter(os.Stderr, isTerminal(os.Stderr))
+	}
+
+	config := cmdOpts.config
+	config.Network = network
+
+	client, err := cfspeed.NewClient(&config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.CloseIdleConnections()
+
+	return cfspeed.RunAndPrint(ctx, resultWriter, client, &cfspeed.RunOptions{
+		Multiplicity:          cmdOpts.multiplicity,
+		MeasureRTT:            cmdOpts.phases.rtt && !cmdOpts.noRTT,
+		SkipDownlink:          !cmdOpts.phases.downlink,
+		SkipUplink:            !cmdOpts.phases.uplink,
+		MeasureBidirectional:  cmdOpts.bidirectional,
+		MeasureResponsiveness: (cmdOpts.phases.responsiveness || cmdOpts.rpm) && !strings.HasPrefix(network, cfspeed.NetworkUDP),
+		Thresholds:            &cmdOpts.thresholds,
+		ScoreThresholds:       cmdOpts.scoreThresholds,
+
+		KeepMeasurements: cmdOpts.record != "",
+		Progress:         progress,
+	})
+}
+
+// getSpeedPhases lists the speed measurement phases of each run in order
+func getSpeedPhases(cmdOpts *CmdOpts) []string {
+	speedPhases := []string{}
+
+	if cmdOpts.phases.downlink {
+		speedPhases = append(speedPhases, cfspeed.PhaseDownlink)
+	}
+	if cmdOpts.phases.uplink {
+		speedPhases = append(speedPhases, cfspeed.PhaseUplink)
+	}
+	if cmdOpts.bidirectional {
+		speedPhases = append(speedPhases, cfspeed.PhaseBidirectional)
+	}
+	if cmdOpts.phases.responsiveness || cmdOpts.rpm {
+		speedPhases = append(speedPhases, cfspeed.PhaseResponsiveness)
+	}
+
+	return speedPhases
+}
+
+// getQUICNetwork maps a TCP network to the UDP one of the same address family, over which HTTP/3 is spoken
+func getQUICNetwork(network string) string {
+	return cfspeed.NetworkUDP + strings.TrimPrefix(network, cfspeed.NetworkTCP)
+}
+
+// getProtocols lists protocols to measure over in the order given, without duplicates
+func getProtocols(cmdOpts *CmdOpts) []string {
+	protocols := []string{}
+
+	for _, protocol := range cmdOpts.protocols {
+		if !slices.Contains(protocols, protocol) {
+			protocols = append(protocols, protocol)
+		}
+	}
+	if cmdOpts.http3 && !slices.Contains(protocols, protocolHTTP3) {
+		protocols = append(protocols, protocolHTTP3)
+	}
+
+	return protocols
+}
+
+func validateProtocols(protocols []string) error {
+	if len(protocols) == 0 {
+		return fmt.Errorf("protocols need to be specified")
+	}
+	for _, protocol := range protocols {
+		if protocol != protocolTCP && protocol != protocolHTTP3 {
+			return fmt.Errorf(`invalid protocol "%s"; it needs to be either tcp or http3`, protocol)
+		}
+	}
+
+	return nil
+}
+
+// getNetworks lists networks to make runs over, by protocol and then by address family so that TCP and QUIC are measured alike
+func getNetworks(cmdOpts *CmdOpts) []string {
+	tcpNetworks := []string{}
+
+	// if none specified, pick up a transport protocol automatically
+	if !cmdOpts.testIP4 && !cmdOpts.testIP6 {
+		tcpNetworks = append(tcpNetworks, cfspeed.NetworkTCP)
+	}
+
+	// these options are not mutually exclusive
+	if cmdOpts.testIP4 {
+		tcpNetworks = append(tcpNetworks, cfspeed.NetworkTCP4)
+	}
+	if cmdOpts.testIP6 {
+		tcpNetworks = append(tcpNetworks, cfspeed.NetworkTCP6)
+	}
+
+	networks := []string{}
+	for _, protocol := range getProtocols(cmdOpts) {
+		for _, network := range tcpNetworks {
+			if protocol == protocolHTTP3 {
+				network = getQUICNetwork(network)
 			}
+			networks = append(networks, network)
+		}
+	}
 
-			return nil
-		},
+	return networks
+}
+
+func runAll(ctx context.Context, resultWriter cfspeed.ResultWriter, cmdOpts *CmdOpts) error {
+	thresholdsMet := true
+	for _, network := range getNetworks(cmdOpts) {
+		result, err := runWithNetwork(ctx, resultWriter, cmdOpts, network)
+		if err != nil {
+			return err
+		}
+
+		thresholdsMet = thresholdsMet && result.ChecksPassed()
 	}
 
-	flags := cmd.Flags()
+	if !thresholdsMet {
+		return errThresholdsNotMet
+	}
 
+	return nil
+}
+
+// validate tells whether the options make sense together, resolving score thresholds on the way
+func (c *CmdOpts) validate() error {
+	var err error = nil
+
+	if c.multiplicity < 1 {
+		return fmt.Errorf(`invalid multiplicity "%d"; it needs to be a positive integer`, c.multiplicity)
+	}
+	if err := c.config.Validate(); err != nil {
+		return err
+	}
+	if err := c.repeat.validate(); err != nil {
+		return err
+	}
+	if err := c.thresholds.Validate(); err != nil {
+		return err
+	}
+	if c.scoreThresholds, err = getScoreThresholds(c.scoreBounds); err != nil {
+		return err
+	}
+	if c.noRTT && c.thresholds.HasRTTChecks() {
+		return fmt.Errorf("RTT thresholds cannot be checked with --no-ping")
+	}
+	if err := c.phases.validateThresholds(&c.thresholds); err != nil {
+		return err
+	}
+	if err := validateProtocols(getProtocols(c)); err != nil {
+		return err
+	}
+	// responsiveness is measured over TCP only, leaving it out of HTTP/3 runs measured alongside
+	if !slices.Contains(getProtocols(c), protocolTCP) && (c.phases.responsiveness || c.rpm) {
+		return fmt.Errorf("responsiveness cannot be measured over HTTP/3")
+	}
+	// the responsiveness phase is bounded by the run timeout as others are, which needs to leave room for its maximum duration
+	if (c.phases.responsiveness || c.rpm) && c.config.Measurement.RunTimeout <= c.config.Measurement.ResponsivenessDurationMax {
+		return fmt.Errorf(`invalid run timeout "%s"; it needs to be longer than the maximum responsiveness measurement duration "%s"`, c.config.Measurement.RunTimeout, c.config.Measurement.ResponsivenessDurationMax)
+	}
+	if c.bidirectional && !c.phases.downlink && !c.phases.uplink {
+		return fmt.Errorf("--bidirectional cannot be combined with commands making no speed measurements")
+	}
+
+	return nil
+}
+
+// getInfluxToken falls back to $INFLUX_TOKEN, which is read here rather than as the default of --influx-token that --help would print
+func getInfluxToken(token string) string {
+	if token != "" {
+		return token
+	}
+
+	return os.Getenv("INFLUX_TOKEN")
+}
+
+func runMeasureCommand(cmd *cobra.Command, cmdOpts *CmdOpts) error {
+	if err := applySettings(cmd.Flags(), &cmdOpts.configFile); err != nil {
+		return err
+	}
+	cmdOpts.sinks.influx.Token = getInfluxToken(cmdOpts.sinks.influx.Token)
+
+	// nothing is to be created, e.g. the history file, for invalid command lines
+	if err := cmdOpts.validate(); err != nil {
+		return err
+	}
+
+	// sinks stop retrying on interruption as well as measurements
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sinkWriters, err := getSinkResultWriters(ctx, &cmdOpts.sinks)
+	if err != nil {
+		return err
+	}
+
+	resultWriter, err := cfspeed.NewResultWriter(cmdOpts.format, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	// history is opt-in so that plain runs create no files of their own
+	if cmdOpts.history || cmdOpts.historyFile != "" {
+		historyStore, err := getHistoryStore(cmdOpts.historyFile)
+		if err != nil {
+			return err
+		}
+		resultWriter = cfspeed.NewMultiResultWriter(resultWriter, historyStore)
+	}
+
+	if cmdOpts.record != "" {
+		traceFile, err := os.Create(cmdOpts.record)
+		if err != nil {
+			return err
+		}
+		defer traceFile.Close()
+		resultWriter = cfspeed.NewMultiResultWriter(resultWriter, cfspeed.NewTraceResultWriter(traceFile))
+	}
+
+	if len(sinkWriters) > 0 {
+		// unavailable sinks are no reason to hold up or give up measurements, nor are they failures of measurements
+		resultWriters := []cfspeed.ResultWriter{resultWriter}
+		for _, sinkWriter := range sinkWriters {
+			resultWriters = append(resultWriters, cfspeed.NewAsyncResultWriter(sinkWriter, sinkQueueSize, func(err error) {
+				errPrinter.Printf("Warning: %v\n", err)
+			}))
+		}
+		resultWriter = cfspeed.NewMultiResultWriter(resultWriters...)
+	}
+
+	// structured formats must not be preceded by anything else
+	if cmdOpts.format == cfspeed.FormatText {
+		printer.Printf(cmd.VersionTemplate())
+	}
+
+	// keep running until interrupted unless the number of runs is given explicitly
+	if (cmdOpts.repeat.interval > 0 || cmdOpts.repeat.schedule != "") && !cmd.Flags().Changed("repeat") {
+		cmdOpts.repeat.repeat = 0
+	}
+
+	if speedPhases := getSpeedPhases(cmdOpts); cmdOpts.config.Measurement.BytesMax > 0 && len(speedPhases) > 0 {
+		errPrinter.Println(getDataUsageEstimate(cmdOpts.config.Measurement.BytesMax, speedPhases, len(getNetworks(cmdOpts)), cmdOpts.repeat.repeat))
+	}
+
+	err = runRepeatedly(ctx, &cmdOpts.repeat, func(ctx context.Context) error {
+		return runAll(ctx, resultWriter, cmdOpts)
+	})
+	if closeErr := resultWriter.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// addThresholdFlags adds flags of thresholds and score thresholds, which runs are assessed by either live or from traces
+func addThresholdFlags(flags *pflag.FlagSet, thresholds *cfspeed.Thresholds, scoreBounds *map[string]string) {
+	flags.Float64Var(&thresholds.MinDownlink, "min-down", 0, "minimum downlink speed in Mbps to be met")
+	flags.Float64Var(&thresholds.MinUplink, "min-up", 0, "minimum uplink speed in Mbps to be met")
+	flags.Float64Var(&thresholds.MaxRTT, "max-rtt", 0, "maximum mean of unloaded RTT in ms to be met")
+	flags.Float64Var(&thresholds.MaxLoadedRTT, "max-loaded-rtt", 0, "maximum mean of loaded RTT in ms to be met")
+	flags.StringVar(&thresholds.SpeedStatistic, "threshold-statistic", cfspeed.SpeedStatisticMean, "statistic compared with speed thresholds (mean, cat, min, max, d1-d9)")
+	flags.StringToStringVar(scoreBounds, "score-thresholds", map[string]string{}, "bounds of bufferbloat grades and use case ratings from the best to the worst, e.g. bufferbloat=5/30/60/200/400,gaming.rtt=20/40/60/100")
+}
+
+func addMeasureFlags(flags *pflag.FlagSet, cmdOpts *CmdOpts) {
 	flags.BoolVarP(&cmdOpts.testIP4, "ip4", "4", false, "ensure measurements over IPv4")
 	flags.BoolVarP(&cmdOpts.testIP6, "ip6", "6", false, "ensure measurements over IPv6")
 	flags.IntVarP(&cmdOpts.multiplicity, "multiplicity", "m", 1, "number of connections in parallel for speed measurements")
 	flags.BoolVarP(&cmdOpts.noRTT, "no-ping", "P", false, "do not measure RTT")
+	flags.BoolVar(&cmdOpts.bidirectional, "bidirectional", false, "also measure downlink and uplink simultaneously after measuring them in turn")
+	flags.BoolVar(&cmdOpts.rpm, "rpm", false, "also measure responsiveness in round-trips per minute at the end")
+	flags.StringSliceVar(&cmdOpts.protocols, "protocols", []string{protocolTCP}, "protocols to measure over in turn (tcp, http3); HTTP/3 runs are labelled udp, udp4 or udp6")
+	flags.BoolVar(&cmdOpts.http3, "http3", false, "also measure over HTTP/3 on QUIC; shorthand for --protocols tcp,http3")
+	flags.StringVarP(&cmdOpts.config.BaseURL, "server", "s", cfspeed.DefaultBaseURL, "base URL of the speed test server")
+	flags.StringVar(&cmdOpts.config.CACertFile, "ca-cert", "", "PEM file of additional CA certificates to trust")
+	flags.BoolVarP(&cmdOpts.config.Insecure, "insecure", "k", false, "do not verify the server certificate")
+	addMeasurementFlags(flags, &cmdOpts.config)
+	flags.IntVarP(&cmdOpts.repeat.repeat, "repeat", "r", 1, "number of runs; 0 to keep running until interrupted, which is implied by --interval and --schedule")
+	flags.DurationVarP(&cmdOpts.repeat.interval, "interval", "i", 0, "interval between starts of runs, e.g. 15m")
+	flags.StringVar(&cmdOpts.repeat.schedule, "schedule", "", `cron expression determining when to run, e.g. "*/15 * * * *"`)
+	flags.DurationVar(&cmdOpts.repeat.jitter, "jitter", 0, "maximum random delay before each run")
+	addThresholdFlags(flags, &cmdOpts.thresholds, &cmdOpts.scoreBounds)
+	flags.StringVar(&cmdOpts.record, "record", "", "JSON Lines file to record raw measurements of every run to for offline analysis, e.g. trace.jsonl")
+	flags.BoolVar(&cmdOpts.history, "history", false, "append results to the history file")
+	flags.StringVar(&cmdOpts.historyFile, "history-file", "", "history file, implying --history (default: $XDG_DATA_HOME/cfspeed/history.jsonl)")
+	flags.BoolVar(&cmdOpts.progress, "progress", false, "show progress of measurements on stderr")
+	addSinkFlags(flags, &cmdOpts.sinks)
+	addConfigFileFlags(flags, &cmdOpts.configFile)
+	flags.StringVarP(&cmdOpts.format, "format", "f", cfspeed.FormatText, "output format (text, json, ndjson, csv, prometheus)")
+}
+
+// newMeasureCommand creates a command measuring the phases given
+func newMeasureCommand(use string, short string, phases PhaseOpts) *cobra.Command {
+	cmdOpts := &CmdOpts{
+		config: *cfspeed.NewConfig(),
+		phases: phases,
+	}
+
+	cmd := &cobra.Command{
+		Use:          use,
+		Short:        short,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runMeasureCommand(cmd, cmdOpts)
+		},
+	}
+
+	addMeasureFlags(cmd.Flags(), cmdOpts)
+
+	return cmd
+}
+
+func main() {
+	fullPhases := PhaseOpts{rtt: true, downlink: true, uplink: true}
+
+	// the root command runs the full sequence as well as the run subcommand
+	cmd := newMeasureCommand("cfspeed", "", fullPhases)
+	cmd.Version = BuildName
+	cmd.Long = rootCmdLong
+
+	cmd.AddCommand(newMeasureCommand("run", "Run the full sequence of measurements (default)", fullPhases))
+	cmd.AddCommand(newMeasureCommand("meta", "Fetch metadata only, e.g. the source IP and the colocation serving it", PhaseOpts{}))
+	cmd.AddCommand(newMeasureCommand("ping", "Measure unloaded RTT only", PhaseOpts{rtt: true}))
+	cmd.AddCommand(newMeasureCommand("down", "Measure downlink speed and RTT", PhaseOpts{rtt: true, downlink: true}))
+	cmd.AddCommand(newMeasureCommand("up", "Measure uplink speed and RTT", PhaseOpts{rtt: true, uplink: true}))
+	cmd.AddCommand(newMeasureCommand("rpm", "Measure responsiveness in round-trips per minute under working conditions", PhaseOpts{responsiveness: true}))
+	cmd.AddCommand(newServeCommand())
+	cmd.AddCommand(newExporterCommand())
+	cmd.AddCommand(newHistoryCommand())
+	cmd.AddCommand(newAnalyseCommand())
 
 	cmd.SetVersionTemplate(fmt.Sprintf("cfspeed %s (%s)\n", BuildName, BuildAnnotation))
 
-	if cmd.Execute() != nil {
-		os.Exit(1)
+	if err := cmd.Execute(); err != nil {
+		if errors.Is(err, errThresholdsNotMet) {
+			os.Exit(exitCodeThresholdsNotMet)
+		}
+		os.Exit(exitCodeFailure)
 	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+func TestPhaseOpts_ValidateThresholds(t *testing.T) {
+	full := &PhaseOpts{rtt: true, downlink: true, uplink: true}
+	assert.NilError(t, full.validateThresholds(&cfspeed.Thresholds{MinDownlink: 1, MinUplink: 1, MaxRTT: 1, MaxLoadedRTT: 1}))
+
+	ping := &PhaseOpts{rtt: true}
+	assert.NilError(t, ping.validateThresholds(&cfspeed.Thresholds{MaxRTT: 1}))
+	assert.ErrorContains(t, ping.validateThresholds(&cfspeed.Thresholds{MinDownlink: 1}), "--min-down")
+	assert.ErrorContains(t, ping.validateThresholds(&cfspeed.Thresholds{MaxLoadedRTT: 1}), "--max-loaded-rtt")
+
+	up := &PhaseOpts{rtt: true, uplink: true}
+	assert.NilError(t, up.validateThresholds(&cfspeed.Thresholds{MinUplink: 1, MaxLoadedRTT: 1}))
+	assert.ErrorContains(t, up.validateThresholds(&cfspeed.Thresholds{MinDownlink: 1}), "--min-down")
+
+	meta := &PhaseOpts{}
+	assert.ErrorContains(t, meta.validateThresholds(&cfspeed.Thresholds{MaxRTT: 1}), "RTT thresholds")
+}
+
+func TestGetScoreThresholds(t *testing.T) {
+	scoreThresholds, err := getScoreThresholds(map[string]string{"bufferbloat": "10/20/40/80/160", "gaming.jitter": "2/4/8/16"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, scoreThresholds.Bufferbloat, []float64{10, 20, 40, 80, 160})
+	assert.DeepEqual(t, scoreThresholds.UseCases[cfspeed.UseCaseGaming][cfspeed.ScoreMetricJitter], []float64{2, 4, 8, 16})
+
+	_, err = getScoreThresholds(map[string]string{"gaming.rtt": "20/forty/60/100"})
+	assert.ErrorContains(t, err, "separated by slashes")
+
+	_, err = getScoreThresholds(map[string]string{"gaming.bandwidth": "1/2/3/4"})
+	assert.ErrorContains(t, err, `invalid metric "bandwidth"`)
+}
+
+func TestGetNetworks_Protocols(t *testing.T) {
+	assert.DeepEqual(t, getNetworks(&CmdOpts{protocols: []string{protocolTCP}}), []string{cfspeed.NetworkTCP})
+	assert.DeepEqual(t, getNetworks(&CmdOpts{protocols: []string{protocolTCP}, http3: true}), []string{cfspeed.NetworkTCP, cfspeed.NetworkUDP})
+	assert.DeepEqual(t, getNetworks(&CmdOpts{protocols: []string{protocolHTTP3}, testIP6: true}), []string{cfspeed.NetworkUDP6})
+	assert.DeepEqual(t, getNetworks(&CmdOpts{protocols: []string{protocolTCP, protocolHTTP3, protocolTCP}, testIP4: true, testIP6: true}),
+		[]string{cfspeed.NetworkTCP4, cfspeed.NetworkTCP6, cfspeed.NetworkUDP4, cfspeed.NetworkUDP6})
+}
+
+func TestValidateProtocols(t *testing.T) {
+	assert.NilError(t, validateProtocols([]string{protocolHTTP3, protocolTCP}))
+	assert.ErrorContains(t, validateProtocols([]string{}), "protocols need to be specified")
+	assert.ErrorContains(t, validateProtocols([]string{"quic"}), `invalid protocol "quic"`)
+}
+
+func TestMeasureCommand_HistoryOptIn(t *testing.T) {
+	server := httptest.NewServer(cfspeed.NewServer(&cfspeed.ServerOptions{DstColo: "NRT"}))
+	t.Cleanup(server.Close)
+
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	cmd := newMeasureCommand("ping", "", PhaseOpts{rtt: true})
+	cmd.SetArgs([]string{"--server", server.URL, "--format", "ndjson", "--rtt-count-max", "1"})
+	assert.NilError(t, cmd.Execute())
+
+	entries, err := os.ReadDir(dataHome)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+
+	cmd = newMeasureCommand("ping", "", PhaseOpts{rtt: true})
+	cmd.SetArgs([]string{"--server", server.URL, "--format", "ndjson", "--rtt-count-max", "1", "--history"})
+	assert.NilError(t, cmd.Execute())
+
+	_, err = os.Stat(filepath.Join(dataHome, "cfspeed", "history.jsonl"))
+	assert.NilError(t, err)
+}
+
+func TestMeasureCommand_InvalidCreatesNothing(t *testing.T) {
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	tracePath := filepath.Join(t.TempDir(), "trace.jsonl")
+
+	cmd := newMeasureCommand("ping", "", PhaseOpts{rtt: true})
+	cmd.SetArgs([]string{"--multiplicity", "0", "--history", "--record", tracePath})
+	cmd.SetErr(io.Discard)
+	assert.ErrorContains(t, cmd.Execute(), "invalid multiplicity")
+
+	entries, err := os.ReadDir(dataHome)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+	_, err = os.Stat(tracePath)
+	assert.Assert(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestGetInfluxToken(t *testing.T) {
+	t.Setenv("INFLUX_TOKEN", "secret")
+
+	assert.Equal(t, getInfluxToken(""), "secret")
+	assert.Equal(t, getInfluxToken("given"), "given")
+}
+
+func TestMeasureCommand_RunTimeoutBoundsResponsiveness(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	cmd := newMeasureCommand("rpm", "", PhaseOpts{responsiveness: true})
+	cmd.SetArgs([]string{"--run-timeout", "15s", "--rpm-duration-max", "20s"})
+	cmd.SetErr(io.Discard)
+	assert.ErrorContains(t, cmd.Execute(), `invalid run timeout "15s"`)
+}
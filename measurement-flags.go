@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+// addMeasurementFlags adds flags bounding measurements, which are common to commands making them
+func addMeasurementFlags(flags *pflag.FlagSet, config *cfspeed.Config) {
+	flags.DurationVar(&config.Measurement.SpeedDuration, "duration", config.Measurement.SpeedDuration, "duration of each of downlink and uplink measurements")
+	flags.Var(newByteSizeValue(&config.Measurement.DownloadSizeMax), "download-size-max", "maximum size of data to be downloaded per request, e.g. 100MB")
+	flags.Var(newByteSizeValue(&config.Measurement.UploadSizeMax), "upload-size-max", "maximum size of data to be uploaded per request, e.g. 100MB")
+	flags.DurationVar(&config.Measurement.RTTDurationMax, "rtt-duration-max", config.Measurement.RTTDurationMax, "maximum duration of each RTT measurement")
+	flags.IntVar(&config.Measurement.RTTCountMax, "rtt-count-max", config.Measurement.RTTCountMax, "maximum number of pings for each RTT measurement")
+	flags.DurationVar(&config.Measurement.ResponsivenessDurationMax, "rpm-duration-max", config.Measurement.ResponsivenessDurationMax, "maximum duration of responsiveness measurement, which ends earlier once stable")
+	flags.Var(newByteSizeValue(&config.Measurement.BytesMax), "max-bytes", "data budget of speed measurements per run, e.g. 200MB; 0 for no limit")
+	flags.DurationVar(&config.DialTimeout, "dial-timeout", config.DialTimeout, "timeout of establishing a connection")
+	flags.DurationVar(&config.Measurement.RunTimeout, "run-timeout", config.Measurement.RunTimeout, "timeout of each phase of a run; needs to be longer than --duration, and than --rpm-duration-max with responsiveness")
+}
+
+// getDataUsageEstimate describes the worst-case data usage under the data budget given, which speed phases share in turn
+func getDataUsageEstimate(bytesMax int64, speedPhases []string, nNetworks int, nRuns int) string {
+	estimate := fmt.Sprintf("Data budget: %s per run", formatByteSize(bytesMax))
+
+	if len(speedPhases) > 1 {
+		shares := []string{fmt.Sprintf("%s up to %s", speedPhases[0], formatByteSize(bytesMax/int64(len(speedPhases))))}
+		for _, speedPhase := range speedPhases[1 : len(speedPhases)-1] {
+			shares = append(shares, fmt.Sprintf("%s up to an even share of the rest", speedPhase))
+		}
+		shares = append(shares, fmt.Sprintf("%s the rest", speedPhases[len(speedPhases)-1]))
+
+		estimate += fmt.Sprintf(" (%s)", strings.Join(shares, ", "))
+	}
+
+	if nRuns == 0 {
+		return estimate + fmt.Sprintf("; worst case %s per repetition over %d protocol(s) until interrupted", formatByteSize(bytesMax*int64(nNetworks)), nNetworks)
+	}
+
+	return estimate + fmt.Sprintf("; worst case %s over %d run(s)", formatByteSize(bytesMax*int64(nNetworks*nRuns)), nNetworks*nRuns)
+}
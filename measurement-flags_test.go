@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+func TestGetDataUsageEstimate(t *testing.T) {
+	speedPhases := []string{cfspeed.PhaseDownlink, cfspeed.PhaseUplink}
+	assert.Equal(t, getDataUsageEstimate(200*1000*1000, speedPhases, 2, 3), "Data budget: 200MB per run (downlink up to 100MB, uplink the rest); worst case 1200MB over 6 run(s)")
+	assert.Equal(t, getDataUsageEstimate(512*1024*1024, speedPhases, 1, 0), "Data budget: 512MiB per run (downlink up to 256MiB, uplink the rest); worst case 512MiB per repetition over 1 protocol(s) until interrupted")
+
+	assert.Equal(t, getDataUsageEstimate(300*1000*1000, append(speedPhases, cfspeed.PhaseBidirectional), 1, 1), "Data budget: 300MB per run (downlink up to 100MB, uplink up to an even share of the rest, bidirectional the rest); worst case 300MB over 1 run(s)")
+	assert.Equal(t, getDataUsageEstimate(100*1000*1000, []string{cfspeed.PhaseUplink}, 1, 1), "Data budget: 100MB per run; worst case 100MB over 1 run(s)")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+// progressLineInterval is the interval of progress lines when they cannot be updated in place
+const progressLineInterval = 1 * time.Second
+
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func formatProgress(event *cfspeed.ProgressEvent) string {
+	switch event.Phase {
+	case cfspeed.PhaseDownlink, cfspeed.PhaseUplink, cfspeed.PhaseBidirectional, cfspeed.PhaseResponsiveness:
+		return fmt.Sprintf("%s: %.1f s, %.3f MiB, %.3f Mbps", event.Phase, event.Elapsed.Seconds(), float64(event.Bytes)/1024/1024, event.MBPS)
+	default:
+		return fmt.Sprintf("%s: %.1f s", event.Phase, event.Elapsed.Seconds())
+	}
+}
+
+// newProgressPrinter renders progress on w, updating a line in place on terminals and printing lines periodically otherwise
+func newProgressPrinter(w io.Writer, inPlace bool) cfspeed.ProgressFunc {
+	lastPrinted := time.Time{}
+	lastPhase := ""
+
+	return func(event *cfspeed.ProgressEvent) {
+		if inPlace {
+			// the line is cleared at the end of each phase so that nothing is left behind results
+			if event.Done {
+				fmt.Fprint(w, "\r\033[K")
+			} else {
+				fmt.Fprintf(w, "\r\033[K%s", formatProgress(event))
+			}
+			return
+		}
+
+		// every phase gets a line however short it is
+		if event.Done || (event.Phase == lastPhase && time.Since(lastPrinted) < progressLineInterval) {
+			return
+		}
+		fmt.Fprintln(w, formatProgress(event))
+		lastPrinted = time.Now()
+		lastPhase = event.Phase
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+type RepeatOpts struct {
+	repeat   int
+	interval time.Duration
+	schedule string
+	jitter   time.Duration
+}
+
+func (r *RepeatOpts) validate() error {
+	if r.repeat < 0 {
+		return fmt.Errorf(`invalid repeat "%d"; it needs to be a non-negative integer`, r.repeat)
+	}
+	if r.interval < 0 {
+		return fmt.Errorf(`invalid interval "%s"; it needs to be non-negative`, r.interval)
+	}
+	if r.jitter < 0 {
+		return fmt.Errorf(`invalid jitter "%s"; it needs to be non-negative`, r.jitter)
+	}
+	if r.interval > 0 && r.schedule != "" {
+		return fmt.Errorf("--interval and --schedule are mutually exclusive")
+	}
+	if r.schedule != "" {
+		if _, err := parseCronSchedule(r.schedule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sleepContext returns false if ctx is done before d elapses
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// runRepeatedly calls runFunc as many times as specified, waiting for the interval or the schedule in between.
+// Cancellation of ctx between runs ends the loop without an error, whereas cancellation during a run yields the error of the run.
+// Failures of individual runs are reported and tolerated unless a single run is requested.
+// Unmet thresholds are reported as such only if no run failed otherwise.
+func runRepeatedly(ctx context.Context, repeatOpts *RepeatOpts, runFunc func(context.Context) error) error {
+	var schedule *cronSchedule = nil
+	if repeatOpts.schedule != "" {
+		var err error = nil
+		if schedule, err = parseCronSchedule(repeatOpts.schedule); err != nil {
+			return err
+		}
+	}
+
+	nextRunAt := time.Now()
+	if schedule != nil {
+		if nextRunAt = schedule.next(nextRunAt); nextRunAt.IsZero() {
+			return fmt.Errorf(`schedule "%s" never matches`, repeatOpts.schedule)
+		}
+	}
+
+	nRuns := 0
+	nFailures := 0
+	measurementsFailed := false
+	for ; repeatOpts.repeat == 0 || nRuns < repeatOpts.repeat; nRuns += 1 {
+		delay := time.Until(nextRunAt)
+		if repeatOpts.jitter > 0 {
+			delay += rand.N(repeatOpts.jitter)
+		}
+		if !sleepContext(ctx, delay) {
+			return nil
+		}
+
+		err := runFunc(ctx)
+		if ctx.Err() != nil {
+			return err
+		}
+		if err != nil {
+			if repeatOpts.repeat == 1 {
+				return err
+			}
+
+			errPrinter.Printf("Error: %v\n", err)
+			nFailures += 1
+			measurementsFailed = measurementsFailed || !errors.Is(err, errThresholdsNotMet)
+		}
+
+		switch {
+		case schedule != nil:
+			// the zero time would otherwise start runs back to back
+			if nextRunAt = schedule.next(time.Now()); nextRunAt.IsZero() {
+				return fmt.Errorf(`schedule "%s" never matches again`, repeatOpts.schedule)
+			}
+		default:
+			nextRunAt = nextRunAt.Add(repeatOpts.interval)
+			if now := time.Now(); nextRunAt.Before(now) {
+				nextRunAt = now
+			}
+		}
+	}
+
+	if measurementsFailed {
+		return fmt.Errorf("%d out of %d runs failed", nFailures, nRuns)
+	}
+	if nFailures > 0 {
+		return fmt.Errorf("%w in %d out of %d runs", errThresholdsNotMet, nFailures, nRuns)
+	}
+
+	return nil
+}
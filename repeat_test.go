@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestRunRepeatedly_Interval(t *testing.T) {
+	runAt := []time.Time{}
+
+	err := runRepeatedly(context.Background(), &RepeatOpts{repeat: 3, interval: 50 * time.Millisecond}, func(_ context.Context) error {
+		runAt = append(runAt, time.Now())
+		return nil
+	})
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(runAt), 3)
+	assert.Assert(t, runAt[2].Sub(runAt[0]) >= 100*time.Millisecond)
+}
+
+func TestRunRepeatedly_ToleratesFailures(t *testing.T) {
+	nRuns := 0
+
+	err := runRepeatedly(context.Background(), &RepeatOpts{repeat: 3}, func(_ context.Context) error {
+		nRuns += 1
+		if nRuns == 2 {
+			return errors.New("dummy")
+		}
+		return nil
+	})
+
+	assert.Equal(t, nRuns, 3)
+	assert.Error(t, err, "1 out of 3 runs failed")
+}
+
+func TestRunRepeatedly_SingleRunFailure(t *testing.T) {
+	err := runRepeatedly(context.Background(), &RepeatOpts{repeat: 1}, func(_ context.Context) error {
+		return errors.New("dummy")
+	})
+
+	assert.Error(t, err, "dummy")
+}
+
+func TestRunRepeatedly_CancelledBetweenRuns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	nRuns := 0
+
+	err := runRepeatedly(ctx, &RepeatOpts{repeat: 0, interval: time.Hour}, func(_ context.Context) error {
+		nRuns += 1
+		cancel()
+		return nil
+	})
+
+	assert.NilError(t, err)
+	assert.Equal(t, nRuns, 1)
+}
+
+func TestRunRepeatedly_CancelledDuringRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := runRepeatedly(ctx, &RepeatOpts{repeat: 0, interval: time.Hour}, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRunRepeatedly_ThresholdsNotMet(t *testing.T) {
+	nRuns := 0
+
+	err := runRepeatedly(context.Background(), &RepeatOpts{repeat: 2}, func(_ context.Context) error {
+		nRuns += 1
+		return errThresholdsNotMet
+	})
+
+	assert.ErrorIs(t, err, errThresholdsNotMet)
+	assert.Error(t, err, "one or more thresholds not met in 2 out of 2 runs")
+}
+
+func TestRunRepeatedly_FailuresPrecedeThresholds(t *testing.T) {
+	nRuns := 0
+
+	err := runRepeatedly(context.Background(), &RepeatOpts{repeat: 2}, func(_ context.Context) error {
+		nRuns += 1
+		if nRuns == 1 {
+			return errThresholdsNotMet
+		}
+		return errors.New("dummy")
+	})
+
+	assert.Assert(t, !errors.Is(err, errThresholdsNotMet))
+	assert.Error(t, err, "2 out of 2 runs failed")
+}
+
+func TestRunRepeatedly_ScheduleNeverMatching(t *testing.T) {
+	nRuns := 0
+
+	err := runRepeatedly(context.Background(), &RepeatOpts{schedule: "0 0 30 2 *"}, func(_ context.Context) error {
+		nRuns += 1
+		return nil
+	})
+
+	assert.ErrorContains(t, err, "never matches")
+	assert.Equal(t, nRuns, 0)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/spf13/cobra"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+const serverShutdownTimeout = 5 * time.Second
+
+type ServeOpts struct {
+	listenAddr  string
+	tlsCertFile string
+	tlsKeyFile  string
+	http3       bool
+	server      cfspeed.ServerOptions
+}
+
+// runHTTPServer serves until SIGINT or SIGTERM is received, and then shuts the server down gracefully.
+// http3Server, if any, serves HTTP/3 alongside over UDP, which needs TLS.
+func runHTTPServer(httpServer *http.Server, http3Server *http3.Server, tlsCertFile, tlsKeyFile string) error {
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return fmt.Errorf("both of --tls-cert and --tls-key need to be specified to enable TLS")
+	}
+	if http3Server != nil && tlsCertFile == "" {
+		return fmt.Errorf("--http3 needs --tls-cert and --tls-key to be specified")
+	}
+
+	var certificates []tls.Certificate = nil
+	if tlsCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+		if err != nil {
+			return fmt.Errorf("could not load the TLS certificate: %w", err)
+		}
+		certificates = []tls.Certificate{certificate}
+		httpServer.TLSConfig = &tls.Config{Certificates: certificates}
+	}
+
+	// listening before announcing lets errors, e.g. of the address in use, surface first and tells the port actually bound, e.g. for ":0"
+	listener, err := net.Listen("tcp", httpServer.Addr)
+	if err != nil {
+		return err
+	}
+
+	var packetConn net.PacketConn = nil
+	if http3Server != nil {
+		http3Server.TLSConfig = http3.ConfigureTLSConfig(&tls.Config{Certificates: certificates})
+
+		// HTTP/3 shares the port bound for TCP, which differs from the address given if its port is 0
+		http3Addr := http3Server.Addr
+		if http3Addr == httpServer.Addr {
+			http3Addr = listener.Addr().String()
+		}
+
+		packetConn, err = net.ListenPacket("udp", http3Addr)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		defer packetConn.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		if tlsCertFile != "" {
+			served <- httpServer.ServeTLS(listener, "", "")
+		} else {
+			served <- httpServer.Serve(listener)
+		}
+	}()
+
+	servedHTTP3 := make(chan error, 1)
+	if http3Server != nil {
+		go func() {
+			servedHTTP3 <- http3Server.Serve(packetConn)
+		}()
+	}
+
+	printer.Printf("Listening on %s\n", listener.Addr())
+	if http3Server != nil {
+		printer.Printf("Listening on %s for HTTP/3\n", packetConn.LocalAddr())
+	}
+
+	select {
+	case err := <-served:
+		return err
+	case err := <-servedHTTP3:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+
+	if http3Server != nil {
+		if err := http3Server.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	}
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func serve(serveOpts *ServeOpts) error {
+	handler := cfspeed.NewServer(&serveOpts.server)
+
+	var http3Server *http3.Server = nil
+	if serveOpts.http3 {
+		http3Server = &http3.Server{
+			Addr:    serveOpts.listenAddr,
+			Handler: handler,
+		}
+	}
+
+	return runHTTPServer(&http.Server{
+		Addr:              serveOpts.listenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}, http3Server, serveOpts.tlsCertFile, serveOpts.tlsKeyFile)
+}
+
+func newServeCommand() *cobra.Command {
+	serveOpts := &ServeOpts{}
+
+	cmd := &cobra.Command{
+		Use:          "serve",
+		Short:        "Serve a Cloudflare-compatible speed test endpoint",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return serve(serveOpts)
+		},
+	}
+
+	flags := cmd.Flags()
+
+	flags.StringVarP(&serveOpts.listenAddr, "listen", "l", ":8080", "address to listen on")
+	flags.StringVar(&serveOpts.tlsCertFile, "tls-cert", "", "PEM file of the TLS certificate; serves plain HTTP if omitted")
+	flags.StringVar(&serveOpts.tlsKeyFile, "tls-key", "", "PEM file of the TLS private key")
+	flags.BoolVar(&serveOpts.http3, "http3", false, "also serve HTTP/3 over UDP on the same address, which needs TLS")
+	flags.StringVar(&serveOpts.server.SrcIP, "meta-ip", "", "source IP reported to clients (default: the address of the client)")
+	flags.StringVar(&serveOpts.server.SrcASN, "meta-asn", "", "source ASN reported to clients")
+	flags.StringVar(&serveOpts.server.SrcCity, "meta-city", "", "source city reported to clients")
+	flags.StringVar(&serveOpts.server.SrcCountry, "meta-country", "", "source country reported to clients")
+	flags.StringVar(&serveOpts.server.DstColo, "meta-colo", "LOCAL", "colocation reported to clients")
+	flags.Int64Var(&serveOpts.server.DownloadSizeMax, "download-max", 0, "maximum size in bytes served per download request (default: 1 GiB)")
+
+	return cmd
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestRunHTTPServer_AddressInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+
+	err = runHTTPServer(&http.Server{Addr: listener.Addr().String()}, nil, "", "")
+	assert.ErrorContains(t, err, "address already in use")
+}
+
+func TestRunHTTPServer_InvalidCertificate(t *testing.T) {
+	err := runHTTPServer(&http.Server{Addr: "127.0.0.1:0"}, nil, "nonexistent.pem", "nonexistent.pem")
+	assert.ErrorContains(t, err, "could not load the TLS certificate")
+}
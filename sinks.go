@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/pflag"
+
+	"github.com/makotom/cfspeed/cfspeed"
+)
+
+// sinkQueueSize is the number of runs waiting to be pushed to each sink, which is plenty for runs of minutes apart to ride out retries
+const sinkQueueSize = 16
+
+type SinkOpts struct {
+	influx   cfspeed.InfluxOptions
+	graphite cfspeed.GraphiteOptions
+	otlp     cfspeed.OTLPOptions
+	webhook  cfspeed.WebhookOptions
+	sink     cfspeed.SinkOptions
+
+	webhookWhen string
+}
+
+// getSinkResultWriters returns writers for the sinks configured, if any
+func getSinkResultWriters(ctx context.Context, sinkOpts *SinkOpts) ([]cfspeed.ResultWriter, error) {
+	writers := []cfspeed.ResultWriter{}
+
+	if sinkOpts.influx.URL != "" {
+		influxWriter, err := cfspeed.NewInfluxResultWriter(ctx, &sinkOpts.influx, &sinkOpts.sink)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, influxWriter)
+	}
+
+	if sinkOpts.graphite.Address != "" {
+		graphiteWriter, err := cfspeed.NewGraphiteResultWriter(ctx, &sinkOpts.graphite, &sinkOpts.sink)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, graphiteWriter)
+	}
+
+	if sinkOpts.otlp.Endpoint != "" {
+		// unversioned builds are named with a backspace, which is no use as an attribute
+		if BuildName != "\b" {
+			sinkOpts.otlp.ServiceVersion = BuildName
+		}
+
+		otlpWriter, err := cfspeed.NewOTLPResultWriter(ctx, &sinkOpts.otlp, &sinkOpts.sink)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, otlpWriter)
+	}
+
+	if sinkOpts.webhook.URL != "" {
+		if sinkOpts.webhookWhen != "" {
+			when, err := cfspeed.ParseWebhookWhen(sinkOpts.webhookWhen)
+			if err != nil {
+				return nil, err
+			}
+			sinkOpts.webhook.When = when
+		}
+
+		webhookWriter, err := cfspeed.NewWebhookResultWriter(ctx, &sinkOpts.webhook)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, webhookWriter)
+	}
+
+	return writers, nil
+}
+
+func addSinkFlags(flags *pflag.FlagSet, sinkOpts *SinkOpts) {
+	sinkOpts.sink = *cfspeed.NewSinkOptions()
+	sinkOpts.webhook = *cfspeed.NewWebhookOptions()
+
+	flags.StringVar(&sinkOpts.influx.URL, "influx-url", "", "base URL of InfluxDB v2 to push results to, e.g. http://localhost:8086")
+	flags.StringVar(&sinkOpts.influx.Org, "influx-org", "", "InfluxDB organisation")
+	flags.StringVar(&sinkOpts.influx.Bucket, "influx-bucket", "", "InfluxDB bucket")
+	flags.StringVar(&sinkOpts.influx.Token, "influx-token", "", "InfluxDB API token (default: $INFLUX_TOKEN)")
+	flags.StringVar(&sinkOpts.graphite.Address, "graphite", "", "host:port of Graphite plaintext listener to push results to")
+	flags.StringVar(&sinkOpts.graphite.Prefix, "graphite-prefix", cfspeed.DefaultGraphitePrefix, "prefix of Graphite series")
+	flags.StringVar(&sinkOpts.otlp.Endpoint, "otlp-endpoint", "", "base URL of OTLP/HTTP receiver to export metrics to, e.g. http://localhost:4318")
+	flags.StringToStringVar(&sinkOpts.otlp.Header, "otlp-header", map[string]string{}, "additional HTTP headers for OTLP export, e.g. Authorization=...")
+	flags.IntVar(&sinkOpts.sink.BatchSize, "sink-batch-size", sinkOpts.sink.BatchSize, "number of runs pushed to sinks at once")
+	flags.IntVar(&sinkOpts.sink.MaxRetries, "sink-retries", sinkOpts.sink.MaxRetries, "number of retries when sinks are unavailable")
+	flags.DurationVar(&sinkOpts.sink.Timeout, "sink-timeout", sinkOpts.sink.Timeout, "timeout of each push to sinks")
+	flags.StringVar(&sinkOpts.webhook.URL, "webhook", "", "URL to POST results of runs to")
+	flags.StringSliceVar(&sinkOpts.webhook.Conditions, "webhook-on", sinkOpts.webhook.Conditions, "conditions of runs to notify the webhook of (always, breach, error) (default: always unless --webhook-when is given)")
+	flags.StringVar(&sinkOpts.webhookWhen, "webhook-when", "", "levels of metrics of which runs breaching any are notified the webhook of, e.g. down<100,up<10,rtt>50,loaded-rtt>200")
+	flags.StringVar(&sinkOpts.webhook.Template, "webhook-template", "", "Go text/template rendering the webhook body from the run (default: the run in JSON)")
+	flags.StringVar(&sinkOpts.webhook.ContentType, "webhook-content-type", sinkOpts.webhook.ContentType, "Content-Type of the webhook body")
+	flags.StringToStringVar(&sinkOpts.webhook.Header, "webhook-header", sinkOpts.webhook.Header, "additional HTTP headers for the webhook")
+	flags.IntVar(&sinkOpts.webhook.MaxRetries, "webhook-retries", sinkOpts.webhook.MaxRetries, "number of retries when the webhook is unavailable")
+	flags.DurationVar(&sinkOpts.webhook.Timeout, "webhook-timeout", sinkOpts.webhook.Timeout, "timeout of each webhook request")
+}